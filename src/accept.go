@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+const (
+	defaultGolden = "first"
+)
+
+// A rule comparing files, whose golden file can be replaced by the actual
+// file
+type acceptRule struct {
+	kind    string // match, jsonMatch, numericMatch or setMatch
+	files   []string
+	negated bool // Under a not group, so the files have to differ
+}
+
+// The rules comparing files in c, including those in its groups
+func acceptRules(c *passConditions, negated bool) (rules []acceptRule) {
+	if c == nil {
+		return
+	}
+	for _, v := range c.Match {
+		rules = append(rules, acceptRule{"match", v.Files, negated})
+	}
+	for _, v := range c.JSONMatch {
+		rules = append(rules, acceptRule{"jsonMatch", v.Files, negated})
+	}
+	for _, v := range c.NumericMatch {
+		rules = append(rules, acceptRule{"numericMatch", v.Files, negated})
+	}
+	for _, v := range c.SetMatch {
+		rules = append(rules, acceptRule{"setMatch", v.Files, negated})
+	}
+	for _, v := range c.AnyOf {
+		rules = append(rules, acceptRules(v, negated)...)
+	}
+	for _, v := range c.AllOf {
+		rules = append(rules, acceptRules(v, negated)...)
+	}
+	return append(rules, acceptRules(c.Not, !negated)...)
+}
+
+// Pick the golden (expected) file and the actual file out of a rule.
+// golden is "first" (e.g., ["output.expected", "output"]), "last" (e.g.,
+// ["output", "output.expected"]) or a filename suffix such as ".expected".
+// Rules with more than two files have more than one actual file, so they
+// can't be accepted.
+func goldenPair(rule acceptRule, golden string) (expected, actual string, err error) {
+	files := rule.files
+	if len(files) < 2 {
+		return "", "", errors.New("Not enough filenames in " + rule.kind + " rule: " + strings.Join(files, ", "))
+	}
+	if len(files) > 2 {
+		return "", "", errors.New("Can't accept a " + rule.kind + " rule with more than two files: " + strings.Join(files, ", "))
+	}
+	switch golden {
+	case "first":
+		return files[0], files[1], nil
+	case "last":
+		return files[1], files[0], nil
+	default:
+		for k, v := range files {
+			if strings.HasSuffix(v, golden) {
+				return v, files[1-k], nil
+			}
+		}
+	}
+	return "", "", errors.New("No golden file (" + golden + ") in " + rule.kind + " rule: " + strings.Join(files, ", "))
+}
+
+func acceptTests(args []string) error {
+	acceptFlags := flag.NewFlagSet("accept", flag.ExitOnError)
+
+	dryRun := acceptFlags.Bool("n", false, "dry run: list the files which would be accepted, but don't change anything")
+	interactive := acceptFlags.Bool("i", false, "ask before accepting each file")
+	golden := acceptFlags.String("golden", config.Golden, "which file in a rule is the golden: first, last, or a filename suffix (e.g., .expected)")
+	verbose := acceptFlags.Bool("verbose", false, "show all output")
+	acceptFlags.Parse(args)
+
+	if *golden == "" {
+		*golden = defaultGolden
+	}
+
+	tests, _ := loadTests(acceptFlags.Args(), *verbose, nil)
+	runTestList(tests, nil)
+
+	stdin := bufio.NewReader(os.Stdin)
+	accepted := 0
+	for e := tests.Front(); e != nil; e = e.Next() {
+		currTest := e.Value.(*test)
+		if !currTest.results.failed() {
+			continue
+		}
+		for p := currTest.rootProfile; p != nil; p = p.Next {
+			for _, rule := range acceptRules(p.Pass, false) {
+				if rule.negated {
+					fmt.Fprintln(os.Stderr, currTest.testName+": Not accepting a "+rule.kind+" rule under not, since its files have to differ: "+strings.Join(rule.files, ", "))
+					continue
+				}
+				expected, actual, err := goldenPair(rule, *golden)
+				if err != nil {
+					fmt.Fprintln(os.Stderr, currTest.testName+": "+err.Error())
+					continue
+				}
+				expected = currTest.testName + "/" + expected
+				actual = currTest.testName + "/" + actual
+				if expected == actual {
+					continue
+				}
+
+				actualBytes, err := ioutil.ReadFile(actual)
+				if err != nil {
+					fmt.Fprintln(os.Stderr, currTest.testName+": Unable to read "+actual+": "+err.Error())
+					continue
+				}
+				expectedBytes, err := ioutil.ReadFile(expected)
+				if err == nil && bytes.Equal(actualBytes, expectedBytes) {
+					continue
+				}
+
+				if *dryRun {
+					fmt.Println("Would accept: " + actual + " -> " + expected)
+					continue
+				}
+				if *interactive {
+					fmt.Print("Accept " + actual + " -> " + expected + "? [y/N] ")
+					answer, _ := stdin.ReadString('\n')
+					answer = strings.ToLower(strings.TrimSpace(answer))
+					if answer != "y" && answer != "yes" {
+						continue
+					}
+				}
+				err = ioutil.WriteFile(expected, actualBytes, 0644)
+				if err != nil {
+					return errors.New("Unable to write " + expected + ": " + err.Error())
+				}
+				fmt.Println("Accepted: " + actual + " -> " + expected)
+				accepted++
+			}
+		}
+	}
+	if !*dryRun {
+		fmt.Printf("%d file(s) accepted\n", accepted)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAcceptRules(t *testing.T) {
+	c := &passConditions{
+		Match:     []fileRule{{Files: []string{"a.expected", "a"}}},
+		JSONMatch: []jsonRule{{Files: []string{"b.expected", "b"}}},
+		AnyOf: []*passConditions{
+			{NumericMatch: []numericRule{{Files: []string{"c.expected", "c"}}}},
+			{SetMatch: []setRule{{Files: []string{"d.expected", "d"}}}},
+		},
+		AllOf: []*passConditions{{Not: &passConditions{Match: []fileRule{{Files: []string{"e", "f"}}}}}},
+	}
+	want := []acceptRule{
+		{"match", []string{"a.expected", "a"}, false},
+		{"jsonMatch", []string{"b.expected", "b"}, false},
+		{"numericMatch", []string{"c.expected", "c"}, false},
+		{"setMatch", []string{"d.expected", "d"}, false},
+		{"match", []string{"e", "f"}, true},
+	}
+	if rules := acceptRules(c, false); !reflect.DeepEqual(rules, want) {
+		t.Errorf("acceptRules = %+v, want %+v", rules, want)
+	}
+	if rules := acceptRules(nil, false); rules != nil {
+		t.Errorf("acceptRules(nil) = %+v", rules)
+	}
+}
+
+func TestGoldenPair(t *testing.T) {
+	tests := []struct {
+		files            []string
+		golden           string
+		expected, actual string
+		ok               bool
+	}{
+		{[]string{"out.expected", "out"}, "first", "out.expected", "out", true},
+		{[]string{"out", "out.expected"}, "last", "out.expected", "out", true},
+		{[]string{"out", "out.expected"}, ".expected", "out.expected", "out", true},
+		{[]string{"out.expected", "out"}, ".expected", "out.expected", "out", true},
+		{[]string{"out", "err"}, ".expected", "", "", false},
+		{[]string{"out"}, "first", "", "", false},
+		{[]string{"out.expected", "out", "out.next"}, "first", "", "", false}, // More than one actual file
+	}
+	for _, tt := range tests {
+		expected, actual, err := goldenPair(acceptRule{kind: "match", files: tt.files}, tt.golden)
+		if expected != tt.expected || actual != tt.actual || (err == nil) != tt.ok {
+			t.Errorf("goldenPair(%q, %q) = %q, %q, %v", tt.files, tt.golden, expected, actual, err)
+		}
+	}
+}
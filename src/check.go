@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Environment variables given to check commands. Paths are relative to the
+// directory yoke is run in, like the commands themselves.
+const (
+	checkTestDirVar  = "YOKE_TEST_DIR"
+	checkStdoutVar   = "YOKE_STDOUT"    // Unset if the test's stdout isn't saved
+	checkStderrVar   = "YOKE_STDERR"    // Unset if the test's stderr isn't saved
+	checkExitCodeVar = "YOKE_EXIT_CODE" // -1 if the command was killed by a signal
+)
+
+// The environment for the check commands of the current step
+func (t *test) checkEnv(r *testResults) (env []string) {
+	env = append(os.Environ(), checkTestDirVar+"="+t.testName)
+	if t.profile.Stdout != nil {
+		env = append(env, checkStdoutVar+"="+t.testName+"/"+*t.profile.Stdout)
+	}
+	if t.profile.Stderr != nil {
+		env = append(env, checkStderrVar+"="+t.testName+"/"+*t.profile.Stderr)
+	}
+	if r.cmd != nil && r.cmd.ProcessState != nil {
+		env = append(env, checkExitCodeVar+"="+strconv.Itoa(r.cmd.ProcessState.ExitCode()))
+	}
+	return
+}
+
+// The output of a check command, cut down to a size fit for a message
+func checkOutput(output []byte) string {
+	if len(output) > excerptSize {
+		return string(output[:excerptSize]) + "\n... (truncated)"
+	}
+	return strings.TrimSuffix(string(output), "\n")
+}
+
+// Run a check command, which passes if it exits with a zero status. Its
+// stdout is included in the failure message.
+func (t *test) runCheck(command string, r *testResults) {
+	if echoCommands {
+		fmt.Println(command)
+	}
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Env = t.checkEnv(r)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := t.startAndWait(cmd, command, r)
+	if err != nil {
+		r.configFail("Unable to run check " + command + ": " + err.Error())
+		return
+	}
+	if stderr.Len() > 0 {
+		r.warn("Check " + command + " wrote to stderr: " + checkOutput(stderr.Bytes()))
+	}
+	if !cmd.ProcessState.Success() {
+		msg := "Check failed (" + describeExitStatus(cmd.ProcessState) + "): " + command
+		if stdout.Len() > 0 {
+			msg += "\n" + checkOutput(stdout.Bytes())
+		}
+		r.fail(msg)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// A repeatable command line flag holding a list of file rules (e.g.,
+// -match a,b -match c,d)
+type ruleListFlag []fileRule
+
+func (r *ruleListFlag) String() string {
+	rules := make([]string, 0, len(*r))
+	for _, v := range *r {
+		rules = append(rules, strings.Join(v.Files, ","))
+	}
+	return strings.Join(rules, " ")
+}
+
+func (r *ruleListFlag) Set(value string) error {
+	*r = append(*r, fileRule{Files: splitList(value)})
+	return nil
+}
+
+// Split a comma-separated flag value, dropping empty entries
+func splitList(value string) (list []string) {
+	list = make([]string, 0)
+	for _, v := range strings.Split(value, ",") {
+		v = strings.TrimSpace(v)
+		if v != "" {
+			list = append(list, v)
+		}
+	}
+	return
+}
+
+// Check that a new test's name is a test directory in the current directory
+func checkTestName(name string) error {
+	if strings.ContainsAny(name, "/"+string(os.PathSeparator)) || strings.Contains(name, "..") {
+		return errors.New("Test name can't contain a path separator or ..: " + name)
+	}
+	if !strings.HasPrefix(name, config.Prefix) || name == config.Prefix {
+		return errors.New("Test name must start with the test prefix (" + config.Prefix + "): " + name)
+	}
+	return nil
+}
+
+func createTest(args []string) error {
+	createFlags := flag.NewFlagSet("create", flag.ExitOnError)
+
+	template := createFlags.String("template", "", "name of the template (from the configuration file) to seed the profile with")
+	command := createFlags.String("command", "", "test command")
+	stdin := createFlags.String("stdin", "", "comma-separated list of files to use as stdin")
+	zeroExit := createFlags.String("zeroExit", "", "whether a zero exit status is required to pass (true or false)")
+	force := createFlags.Bool("force", false, "overwrite an existing test profile")
+	var match, rmatch ruleListFlag
+	createFlags.Var(&match, "match", "comma-separated list of files which must match (may be repeated)")
+	createFlags.Var(&rmatch, "rmatch", "comma-separated regular expression file and files which must match it (may be repeated)")
+	createFlags.Parse(args)
+
+	if createFlags.NArg() != 1 {
+		return errors.New("Exactly one test name must be given")
+	}
+	testdir := createFlags.Arg(0)
+	if err := checkTestName(testdir); err != nil {
+		return err
+	}
+	name := strings.TrimPrefix(testdir, config.Prefix)
+
+	// Seed the new profile
+	var p testProfile
+	if *template == "" {
+		p = config.DefaultProfile
+	} else {
+		t, ok := config.Templates[*template]
+		if !ok {
+			return errors.New("Unknown template: " + *template)
+		}
+		p = t
+	}
+	p.Name = &name
+	if p.Pass != nil {
+		newPass := *p.Pass
+		p.Pass = &newPass
+	}
+
+	// Apply overrides
+	if *command != "" {
+		p.Command = command
+	}
+	if *stdin != "" {
+		p.Stdin = splitList(*stdin)
+	}
+	if *zeroExit != "" || match != nil || rmatch != nil {
+		if p.Pass == nil {
+			p.Pass = new(passConditions)
+		}
+	}
+	if *zeroExit != "" {
+		b, err := strconv.ParseBool(*zeroExit)
+		if err != nil {
+			return errors.New("Invalid value for -zeroExit: " + *zeroExit)
+		}
+		p.Pass.ZeroExit = &b
+	}
+	if match != nil {
+		p.Pass.Match = match
+	}
+	if rmatch != nil {
+		p.Pass.Rmatch = rmatch
+	}
+
+	// Create the test directory and profile
+	profileName := testdir + "/" + profileFileName
+	if _, err := os.Stat(profileName); err == nil && !*force {
+		return errors.New("Test profile already exists: " + profileName)
+	}
+	err := os.MkdirAll(testdir, os.ModePerm)
+	if err != nil {
+		return errors.New("Unable to create test directory: " + err.Error())
+	}
+	profileBytes, err := json.MarshalIndent(p, "", "\t")
+	if err != nil {
+		return errors.New("Unable to marshal profile JSON: " + err.Error())
+	}
+	err = ioutil.WriteFile(profileName, append(profileBytes, '\n'), 0644)
+	if err != nil {
+		return errors.New("Unable to write test profile: " + err.Error())
+	}
+
+	// Create placeholders for the required files
+	for _, v := range p.RequiredFiles {
+		f, err := os.OpenFile(testdir+"/"+v, os.O_CREATE|os.O_RDWR, 0644)
+		if err != nil {
+			return errors.New("Unable to create required file: " + v + ": " + err.Error())
+		}
+		f.Close()
+	}
+
+	fmt.Println("Test created: " + testdir)
+	return nil
+}
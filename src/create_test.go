@@ -0,0 +1,20 @@
+package main
+
+import "testing"
+
+func TestCheckTestName(t *testing.T) {
+	prefix := config.Prefix
+	defer func() { config.Prefix = prefix }()
+	config.Prefix = "test-"
+
+	for _, name := range []string{"test-a", "test-a.b"} {
+		if err := checkTestName(name); err != nil {
+			t.Errorf("checkTestName(%q) = %v", name, err)
+		}
+	}
+	for _, name := range []string{"a", "test-", "x-test-a", "test-a/b", "test-../x", "../test-a", "/test-a"} {
+		if checkTestName(name) == nil {
+			t.Errorf("checkTestName(%q) didn't fail", name)
+		}
+	}
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultDiffContext  = 3
+	defaultDiffMaxLines = 200
+	defaultDiffMaxBytes = 16384
+	binarySniffLen      = 8000 // Number of bytes to check for NULs when deciding if a file is binary
+	diffDirName         = "diffs"
+	diffMaxFileSize     = 4 << 20 // Files larger than this are compared without a diff
+	diffMaxEdits        = 2000    // Files needing more inserted and deleted lines than this aren't diffed
+	matchReadBufferSize = 1024    // Buffer size to use when doing a byte comparison of files
+)
+
+// Diff settings from the configuration file
+type diffConfig struct {
+	Context  *int `json:"context,omitempty"`
+	MaxLines *int `json:"maxLines,omitempty"`
+	MaxBytes *int `json:"maxBytes,omitempty"`
+}
+
+func (d *diffConfig) fixNullReferences() {
+	if d.Context == nil || *d.Context < 0 {
+		newContext := defaultDiffContext
+		d.Context = &newContext
+	}
+	if d.MaxLines == nil {
+		newMaxLines := defaultDiffMaxLines
+		d.MaxLines = &newMaxLines
+	}
+	if d.MaxBytes == nil {
+		newMaxBytes := defaultDiffMaxBytes
+		d.MaxBytes = &newMaxBytes
+	}
+}
+
+type diffOp struct {
+	kind byte // ' ' (same), '-' (only in a) or '+' (only in b)
+	line string
+}
+
+// Split text into lines, keeping the line endings, so a missing newline at
+// the end of a file shows up as a difference
+func splitLines(text []byte) (lines []string) {
+	lines = make([]string, 0)
+	s := string(text)
+	for len(s) > 0 {
+		i := strings.IndexByte(s, '\n')
+		if i < 0 {
+			lines = append(lines, s)
+			break
+		}
+		lines = append(lines, s[:i+1])
+		s = s[i+1:]
+	}
+	return
+}
+
+// Compute the shortest edit script turning a into b (Myers' algorithm).
+// Returns false, without an edit script, if it would take more than
+// diffMaxEdits edits, since the time and memory needed grow with their
+// square.
+func diffLines(a, b []string) (ops []diffOp, ok bool) {
+	n, m := len(a), len(b)
+	max := n + m
+	offset := max + 1
+	v := make([]int, 2*max+3)
+	// Only the diagonals which can be reached in d edits (-d-1 to d+1) are
+	// kept for each d
+	trace := make([][]int, 0)
+	found := false
+
+search:
+	for d := 0; d <= max; d++ {
+		if d > diffMaxEdits {
+			break
+		}
+		trace = append(trace, append([]int(nil), v[offset-d-1:offset+d+2]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				found = true
+				break search
+			}
+		}
+	}
+	if !found {
+		return nil, false
+	}
+
+	// Walk back through the trace to build the edit script
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		vd := trace[d]
+		base := d + 1 // Index of diagonal 0 in vd
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && vd[base+k-1] < vd[base+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := vd[base+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x--
+			y--
+			ops = append(ops, diffOp{' ', a[x]})
+		}
+		if d > 0 {
+			if x == prevX {
+				y--
+				ops = append(ops, diffOp{'+', b[y]})
+			} else {
+				x--
+				ops = append(ops, diffOp{'-', a[x]})
+			}
+		}
+	}
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops, true
+}
+
+// Compare two files a chunk at a time, without reading them into memory
+func filesEqual(aName, bName string) (bool, error) {
+	a, err := os.Open(aName)
+	if err != nil {
+		return false, err
+	}
+	defer a.Close()
+	b, err := os.Open(bName)
+	if err != nil {
+		return false, err
+	}
+	defer b.Close()
+	aBuf := make([]byte, matchReadBufferSize)
+	bBuf := make([]byte, matchReadBufferSize)
+	for {
+		aRead, aErr := io.ReadFull(a, aBuf)
+		bRead, bErr := io.ReadFull(b, bBuf)
+		if aRead != bRead || !bytes.Equal(aBuf[:aRead], bBuf[:bRead]) {
+			return false, nil
+		}
+		if aErr == io.EOF || aErr == io.ErrUnexpectedEOF {
+			return bErr == io.EOF || bErr == io.ErrUnexpectedEOF, nil
+		}
+		if aErr != nil {
+			return false, aErr
+		}
+		if bErr != nil && bErr != io.EOF && bErr != io.ErrUnexpectedEOF {
+			return false, bErr
+		}
+	}
+}
+
+// Check that files are small enough to diff
+func checkDiffable(names ...string) error {
+	for _, name := range names {
+		fi, err := os.Stat(name)
+		if err != nil {
+			return err
+		}
+		if fi.Size() > diffMaxFileSize {
+			return errors.New(name + " is too large to diff (over " + strconv.Itoa(diffMaxFileSize) + " bytes)")
+		}
+	}
+	return nil
+}
+
+func isBinary(data []byte) bool {
+	if len(data) > binarySniffLen {
+		data = data[:binarySniffLen]
+	}
+	return bytes.IndexByte(data, 0) >= 0
+}
+
+// Offset of the first byte which differs between a and b
+func firstDifference(a, b []byte) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
+}
+
+// Format a hunk range, following the diff -u conventions
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return strconv.Itoa(start) + ",0"
+	}
+	if count == 1 {
+		return strconv.Itoa(start + 1)
+	}
+	return strconv.Itoa(start+1) + "," + strconv.Itoa(count)
+}
+
+// Build a unified diff of two files. Binary files are only reported with
+// the offset of the first differing byte. The diff is truncated according
+// to the diff settings in the configuration file.
+func unifiedDiff(aName, bName string, a, b []byte) string {
+	if isBinary(a) || isBinary(b) {
+		return "Binary files differ at byte offset " + strconv.Itoa(firstDifference(a, b))
+	}
+	context := *config.Diff.Context
+
+	ops, ok := diffLines(splitLines(a), splitLines(b))
+	if !ok {
+		return "Files differ in more than " + strconv.Itoa(diffMaxEdits) + " lines (no diff shown)"
+	}
+
+	// Line positions before each op
+	aPos := make([]int, len(ops)+1)
+	bPos := make([]int, len(ops)+1)
+	for i, op := range ops {
+		aPos[i+1], bPos[i+1] = aPos[i], bPos[i]
+		if op.kind != '+' {
+			aPos[i+1]++
+		}
+		if op.kind != '-' {
+			bPos[i+1]++
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("--- " + aName + "\n")
+	buf.WriteString("+++ " + bName + "\n")
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+		// Grow the hunk until there is a long enough run of unchanged lines
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for end < len(ops) {
+			if ops[end].kind != ' ' {
+				end++
+				continue
+			}
+			j := end
+			for j < len(ops) && ops[j].kind == ' ' {
+				j++
+			}
+			if j < len(ops) && j-end <= 2*context {
+				end = j
+				continue
+			}
+			end += context
+			if end > len(ops) {
+				end = len(ops)
+			}
+			break
+		}
+
+		buf.WriteString("@@ -" + hunkRange(aPos[start], aPos[end]-aPos[start]) +
+			" +" + hunkRange(bPos[start], bPos[end]-bPos[start]) + " @@\n")
+		for _, op := range ops[start:end] {
+			buf.WriteByte(op.kind)
+			buf.WriteString(op.line)
+			if !strings.HasSuffix(op.line, "\n") {
+				buf.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		i = end
+	}
+
+	return truncateDiff(strings.TrimSuffix(buf.String(), "\n"))
+}
+
+// Cap a diff at the configured number of lines and bytes
+func truncateDiff(diff string) string {
+	maxLines, maxBytes := *config.Diff.MaxLines, *config.Diff.MaxBytes
+	lines := strings.Split(diff, "\n")
+	total := len(lines)
+	if maxLines > 0 && len(lines) > maxLines {
+		lines = lines[:maxLines]
+	}
+	size := 0
+	for k, v := range lines {
+		size += len(v) + 1
+		if maxBytes > 0 && size > maxBytes {
+			lines = lines[:k]
+			break
+		}
+	}
+	if len(lines) < total {
+		lines = append(lines, "... diff truncated ("+strconv.Itoa(total-len(lines))+" more lines)")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Add terminal colors to the lines of a diff
+func colorizeDiff(diff string) string {
+	lines := strings.Split(diff, "\n")
+	for k, v := range lines {
+		switch {
+		case strings.HasPrefix(v, "+++"), strings.HasPrefix(v, "---"):
+			lines[k] = "\x1b[1m" + v + "\x1b[0m"
+		case strings.HasPrefix(v, "@@"):
+			lines[k] = "\x1b[36m" + v + "\x1b[0m"
+		case strings.HasPrefix(v, "+"):
+			lines[k] = "\x1b[32m" + v + "\x1b[0m"
+		case strings.HasPrefix(v, "-"):
+			lines[k] = "\x1b[31m" + v + "\x1b[0m"
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Report whether f is a terminal
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+func diffFileName(testName string) string {
+	return stateDir + "/" + diffDirName + "/" + testName + ".diff"
+}
+
+// Save the diffs from the last run of a test, so they can be shown again
+// with 'yoke diff'
+func (t *test) saveDiffs() error {
+	filename := diffFileName(t.testName)
+	if len(t.results.diffs) == 0 {
+		os.Remove(filename)
+		return nil
+	}
+	err := os.MkdirAll(stateDir+"/"+diffDirName, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, []byte(strings.Join(t.results.diffs, "\n")+"\n"), 0644)
+}
+
+func showDiffs(args []string) error {
+	diffFlags := flag.NewFlagSet("diff", flag.ExitOnError)
+	color := diffFlags.Bool("color", isTerminal(os.Stdout), "colorize the diff")
+	diffFlags.Parse(args)
+
+	if diffFlags.NArg() < 1 {
+		return errors.New("No test specified")
+	}
+	for _, name := range diffFlags.Args() {
+		name = strings.TrimSuffix(name, "/")
+		diffBytes, err := ioutil.ReadFile(diffFileName(name))
+		if os.IsNotExist(err) {
+			fmt.Println(name + ": no differences recorded in the last run")
+			continue
+		} else if err != nil {
+			return errors.New("Unable to read diff for " + name + ": " + err.Error())
+		}
+		diff := string(diffBytes)
+		if *color {
+			diff = colorizeDiff(diff)
+		}
+		fmt.Print(diff)
+	}
+	return nil
+}
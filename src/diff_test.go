@@ -0,0 +1,160 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func init() {
+	config.Diff.fixNullReferences()
+}
+
+// Apply an edit script, returning the two sides it was computed from
+func applyOps(ops []diffOp) (a, b []string) {
+	a, b = make([]string, 0), make([]string, 0)
+	for _, op := range ops {
+		if op.kind != '+' {
+			a = append(a, op.line)
+		}
+		if op.kind != '-' {
+			b = append(b, op.line)
+		}
+	}
+	return
+}
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		a, b  string
+		edits int
+	}{
+		{"", "", 0},
+		{"a\nb\nc\n", "a\nb\nc\n", 0},
+		{"", "a\nb\n", 2},
+		{"a\nb\n", "", 2},
+		{"a\nb\nc\n", "a\nc\n", 1},
+		{"a\nc\n", "a\nb\nc\n", 1},
+		{"a\nb\nc\n", "a\nx\nc\n", 2},
+		{"a\nb\nc\nd\n", "d\nc\nb\na\n", 6},
+		{"a\nb", "a\nb\n", 2}, // Missing newline at the end
+	}
+	for _, tt := range tests {
+		ops, ok := diffLines(splitLines([]byte(tt.a)), splitLines([]byte(tt.b)))
+		if !ok {
+			t.Errorf("diffLines(%q, %q) gave up", tt.a, tt.b)
+			continue
+		}
+		a, b := applyOps(ops)
+		if strings.Join(a, "") != tt.a || strings.Join(b, "") != tt.b {
+			t.Errorf("diffLines(%q, %q) = %v, which gives %q, %q", tt.a, tt.b, ops, a, b)
+		}
+		edits := 0
+		for _, op := range ops {
+			if op.kind != ' ' {
+				edits++
+			}
+		}
+		if edits != tt.edits {
+			t.Errorf("diffLines(%q, %q) took %d edits, want %d", tt.a, tt.b, edits, tt.edits)
+		}
+	}
+}
+
+func TestDiffLinesEditLimit(t *testing.T) {
+	a := make([]string, 0)
+	b := make([]string, 0)
+	for k := 0; k < diffMaxEdits; k++ {
+		a = append(a, "a"+strconv.Itoa(k)+"\n")
+		b = append(b, "b"+strconv.Itoa(k)+"\n")
+	}
+	if _, ok := diffLines(a, b); ok {
+		t.Errorf("diffLines with %d edits didn't give up", 2*diffMaxEdits)
+	}
+	if _, ok := diffLines(a[:diffMaxEdits/2], b[:diffMaxEdits/2]); !ok {
+		t.Errorf("diffLines with %d edits gave up", diffMaxEdits)
+	}
+	if !strings.Contains(unifiedDiff("a", "b", []byte(strings.Join(a, "")), []byte(strings.Join(b, ""))), "no diff shown") {
+		t.Errorf("unifiedDiff of files which differ too much wasn't replaced with a message")
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	diff := unifiedDiff("exp", "act", []byte("1\n2\n3\n4\n5\n6\n7\n8\n9\n"), []byte("1\n2\n3\n4\nfive\n6\n7\n8\n9\n"))
+	want := "--- exp\n+++ act\n@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8"
+	if diff != want {
+		t.Errorf("unifiedDiff = %q, want %q", diff, want)
+	}
+
+	diff = unifiedDiff("exp", "act", []byte("a"), []byte("a\n"))
+	if !strings.Contains(diff, "\\ No newline at end of file") {
+		t.Errorf("unifiedDiff doesn't report the missing newline: %q", diff)
+	}
+
+	diff = unifiedDiff("exp", "act", []byte("ab\x00c"), []byte("ab\x00d"))
+	if diff != "Binary files differ at byte offset 3" {
+		t.Errorf("unifiedDiff of binary files = %q", diff)
+	}
+}
+
+func TestTruncateDiff(t *testing.T) {
+	maxLines, maxBytes := *config.Diff.MaxLines, *config.Diff.MaxBytes
+	defer func() { *config.Diff.MaxLines, *config.Diff.MaxBytes = maxLines, maxBytes }()
+
+	*config.Diff.MaxLines, *config.Diff.MaxBytes = 2, 0
+	if got := truncateDiff("a\nb\nc\nd"); got != "a\nb\n... diff truncated (2 more lines)" {
+		t.Errorf("truncateDiff by lines = %q", got)
+	}
+	*config.Diff.MaxLines, *config.Diff.MaxBytes = 0, 5
+	if got := truncateDiff("aa\nbb\ncc"); got != "aa\n... diff truncated (2 more lines)" {
+		t.Errorf("truncateDiff by bytes = %q", got)
+	}
+}
+
+func TestFilesEqual(t *testing.T) {
+	dir, err := ioutil.TempDir("", "yoke")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	chunk := strings.Repeat("x", matchReadBufferSize)
+	tests := []struct {
+		a, b  string
+		equal bool
+	}{
+		{"", "", true},
+		{"abc", "abc", true},
+		{chunk + chunk, chunk + chunk, true},
+		{"abc", "abd", false},
+		{"abc", "abcd", false},
+		{chunk, chunk + "x", false},
+		{chunk + "x", chunk + "y", false},
+	}
+	for k, tt := range tests {
+		aName := filepath.Join(dir, strconv.Itoa(k)+"a")
+		bName := filepath.Join(dir, strconv.Itoa(k)+"b")
+		ioutil.WriteFile(aName, []byte(tt.a), 0644)
+		ioutil.WriteFile(bName, []byte(tt.b), 0644)
+		equal, err := filesEqual(aName, bName)
+		if err != nil || equal != tt.equal {
+			t.Errorf("filesEqual(%d) = %v, %v; want %v", k, equal, err, tt.equal)
+		}
+	}
+}
+
+func TestColorizeDiffs(t *testing.T) {
+	diff := "--- a\n+++ b\n@@ -1 +1 @@\n-x\n+y"
+	r := testResults{diffs: []string{diff}}
+	msg := r.colorizeDiffs("Files don't match: a, b\n" + diff)
+	if !strings.HasPrefix(msg, "Files don't match: a, b\n\x1b[1m--- a") {
+		t.Errorf("colorizeDiffs = %q", msg)
+	}
+	plain := "- not a diff\n+ also not a diff"
+	if got := r.colorizeDiffs(plain); got != plain {
+		t.Errorf("colorizeDiffs changed a message without a diff: %q", got)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// Event actions
+const (
+	eventSuiteStart   = "suite-start"   // The run started
+	eventSuiteEnd     = "suite-end"     // The run finished
+	eventStart        = "start"         // A test started
+	eventStepEnd      = "step-end"      // A step of a test finished
+	eventCommandStart = "command-start" // A command started
+	eventCommandEnd   = "command-end"   // A command finished
+	eventOutputLimit  = "output-limit"  // A command reached the output limit
+	eventTimeLimit    = "time-limit"    // A command was killed for reaching the time limit
+	eventInfo         = "info"
+	eventWarning      = "warning"
+	eventFailure      = "failure"
+	eventPass         = "pass"  // A test finished, and passed
+	eventFail         = "fail"  // A test finished, and failed
+	eventSkip         = "skip"  // A test was skipped
+	eventXfail        = "xfail" // A test finished, and failed as expected
+)
+
+// Something which happened while running a test
+type testEvent struct {
+	Time       time.Time     `json:"time"`
+	Action     string        `json:"action"`
+	Test       string        `json:"test,omitempty"`
+	Step       int           `json:"step,omitempty"` // 1 for the test itself, 2 for its next step, etc.
+	StepName   string        `json:"stepName,omitempty"`
+	Command    string        `json:"command,omitempty"`
+	ExitCode   *int          `json:"exitCode,omitempty"`
+	ExitStatus string        `json:"exitStatus,omitempty"`
+	Elapsed    *float64      `json:"elapsed,omitempty"` // Seconds
+	Message    string        `json:"message,omitempty"`
+	Failures   []string      `json:"failures,omitempty"` // For step-end
+	Tests      []string      `json:"tests,omitempty"`    // For suite-start: the tests which will be run
+	Summary    *eventSummary `json:"summary,omitempty"`  // For suite-end
+}
+
+// The totals for a run, in a suite-end event
+type eventSummary struct {
+	Passed           int      `json:"passed"`
+	Failed           int      `json:"failed"`
+	Skipped          int      `json:"skipped"`
+	ExpectedFailures int      `json:"expectedFailures"`
+	FailedTests      []string `json:"failedTests,omitempty"`
+}
+
+// Receives events as they happen, possibly from several goroutines at once
+type eventListener interface {
+	event(e *testEvent)
+}
+
+// Where the events of newly loaded tests are sent (nil for nowhere)
+var defaultEventListener eventListener
+
+// Passes events on to several listeners
+type eventListenerList []eventListener
+
+func (l eventListenerList) event(e *testEvent) {
+	for _, v := range l {
+		v.event(e)
+	}
+}
+
+// Holds events back until the run has started, then passes them on. Tests
+// send events while they're loaded, which is before the suite-start event
+// (since it lists the tests loaded).
+type eventBuffer struct {
+	mu      sync.Mutex
+	target  eventListener
+	started bool
+	held    []*testEvent
+}
+
+func (b *eventBuffer) event(e *testEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.started {
+		b.held = append(b.held, e)
+		return
+	}
+	b.target.event(e)
+}
+
+// Pass on the events held so far, and any after them
+func (b *eventBuffer) start() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.started = true
+	for _, v := range b.held {
+		b.target.event(v)
+	}
+	b.held = nil
+}
+
+// Writes events as newline-delimited JSON
+type jsonEventWriter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func newJSONEventWriter(w io.Writer) *jsonEventWriter {
+	return &jsonEventWriter{enc: json.NewEncoder(w)}
+}
+
+func (j *jsonEventWriter) event(e *testEvent) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.enc.Encode(e)
+}
+
+func seconds(d time.Duration) *float64 {
+	s := d.Seconds()
+	return &s
+}
+
+// Send an event about the test to the listener (if any), filling in the
+// time, test name and step
+func (r *testResults) emit(e testEvent) {
+	if r.events == nil {
+		return
+	}
+	e.Time = time.Now()
+	e.Test = *r.testName
+	switch e.Action {
+	case eventPass, eventFail, eventSkip, eventXfail: // About the whole test
+	default:
+		if len(r.steps) > 0 {
+			e.Step = len(r.steps)
+			e.StepName = r.currentStep().name
+		}
+	}
+	r.events.event(&e)
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+type recordedEvents []string
+
+func (r *recordedEvents) event(e *testEvent) {
+	*r = append(*r, e.Action)
+}
+
+func TestEventBuffer(t *testing.T) {
+	var recorded recordedEvents
+	b := &eventBuffer{target: &recorded}
+	b.event(&testEvent{Action: eventInfo})
+	if len(recorded) != 0 {
+		t.Errorf("events passed on before the start: %q", recorded)
+	}
+	recorded.event(&testEvent{Action: eventSuiteStart})
+	b.start()
+	b.event(&testEvent{Action: eventPass})
+	if want := (recordedEvents{eventSuiteStart, eventInfo, eventPass}); !reflect.DeepEqual(recorded, want) {
+		t.Errorf("events = %q, want %q", recorded, want)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Signals which can be named in the signal pass condition
+var signalNames = map[string]syscall.Signal{
+	"SIGABRT": syscall.SIGABRT,
+	"SIGALRM": syscall.SIGALRM,
+	"SIGBUS":  syscall.SIGBUS,
+	"SIGFPE":  syscall.SIGFPE,
+	"SIGHUP":  syscall.SIGHUP,
+	"SIGILL":  syscall.SIGILL,
+	"SIGINT":  syscall.SIGINT,
+	"SIGKILL": syscall.SIGKILL,
+	"SIGPIPE": syscall.SIGPIPE,
+	"SIGQUIT": syscall.SIGQUIT,
+	"SIGSEGV": syscall.SIGSEGV,
+	"SIGSYS":  syscall.SIGSYS,
+	"SIGTERM": syscall.SIGTERM,
+	"SIGTRAP": syscall.SIGTRAP,
+	"SIGUSR1": syscall.SIGUSR1,
+	"SIGUSR2": syscall.SIGUSR2,
+	"SIGXCPU": syscall.SIGXCPU,
+	"SIGXFSZ": syscall.SIGXFSZ,
+}
+
+// Look up a signal by name, with or without the SIG prefix
+func parseSignal(name string) (syscall.Signal, error) {
+	name = strings.ToUpper(name)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	sig, ok := signalNames[name]
+	if !ok {
+		return 0, errors.New("Unknown signal: " + name)
+	}
+	return sig, nil
+}
+
+func signalName(sig syscall.Signal) string {
+	for k, v := range signalNames {
+		if v == sig {
+			return k
+		}
+	}
+	return "signal " + strconv.Itoa(int(sig))
+}
+
+// The allowed exit codes of the exitCode pass condition, which can be given
+// as a number or a list of numbers
+type exitCodes []int
+
+func (e *exitCodes) UnmarshalJSON(data []byte) error {
+	if string(bytes.TrimSpace(data)) == "null" { // Left unset
+		return nil
+	}
+	var code int
+	if json.Unmarshal(data, &code) == nil {
+		*e = exitCodes{code}
+		return nil
+	}
+	var codes []int
+	if json.Unmarshal(data, &codes) != nil {
+		return errors.New("exitCode must be a number or a list of numbers")
+	}
+	*e = codes
+	return nil
+}
+
+func (e exitCodes) MarshalJSON() ([]byte, error) {
+	if len(e) == 1 {
+		return json.Marshal(e[0])
+	}
+	return json.Marshal([]int(e))
+}
+
+func (e exitCodes) String() string {
+	codes := make([]string, 0, len(e))
+	for _, v := range e {
+		codes = append(codes, strconv.Itoa(v))
+	}
+	return strings.Join(codes, " or ")
+}
+
+// Describe how a command finished
+func describeExitStatus(state *os.ProcessState) string {
+	if state == nil {
+		return "command didn't run"
+	}
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return "killed by " + signalName(status.Signal())
+	}
+	return "exit code " + strconv.Itoa(state.ExitCode())
+}
+
+// Check how the test command finished against the exitCode and signal pass
+// conditions. When both are given, either may be met. Commands are run by
+// sh, which reports a command killed by signal n as exit code 128+n when it
+// doesn't exec the command itself, so that counts as being killed too.
+func (r *testResults) checkExitStatus(state *os.ProcessState, codes exitCodes, signal *string) {
+	expected := make([]string, 0, 2)
+	if len(codes) > 0 {
+		expected = append(expected, "exit code "+codes.String())
+	}
+	var sig syscall.Signal
+	if signal != nil {
+		var err error
+		sig, err = parseSignal(*signal)
+		if err != nil {
+			r.configFail(err.Error())
+			return
+		}
+		expected = append(expected, "killed by "+signalName(sig))
+	}
+
+	if state != nil {
+		status, _ := state.Sys().(syscall.WaitStatus)
+		for _, v := range codes {
+			if !status.Signaled() && state.ExitCode() == v {
+				return
+			}
+		}
+		if signal != nil {
+			if status.Signaled() && status.Signal() == sig {
+				return
+			}
+			if !status.Signaled() && state.ExitCode() == 128+int(sig) {
+				return
+			}
+		}
+	}
+	r.fail("Wrong exit status: " + describeExitStatus(state) + " (expected " + strings.Join(expected, ", or ") + ")")
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestExitCodesUnmarshal(t *testing.T) {
+	tests := []struct {
+		json  string
+		codes exitCodes
+	}{
+		{`{"exitCode": 2}`, exitCodes{2}},
+		{`{"exitCode": [1, 3]}`, exitCodes{1, 3}},
+		{`{"exitCode": null}`, nil},
+		{`{}`, nil},
+	}
+	for _, tt := range tests {
+		var c passConditions
+		if err := json.Unmarshal([]byte(tt.json), &c); err != nil {
+			t.Errorf("Unmarshal(%s): %v", tt.json, err)
+			continue
+		}
+		if !reflect.DeepEqual(c.ExitCode, tt.codes) {
+			t.Errorf("Unmarshal(%s) = %#v, want %#v", tt.json, c.ExitCode, tt.codes)
+		}
+	}
+
+	var c passConditions
+	if err := json.Unmarshal([]byte(`{"exitCode": "1"}`), &c); err == nil {
+		t.Errorf("Unmarshal of a string exit code didn't fail")
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"regexp"
+)
+
+// Test selection flags shared by the commands which work on sets of tests
+type testFilter struct {
+	run         string
+	skip        string
+	tags        string
+	excludeTags string
+	shard       string
+	balance     bool
+	failed      bool
+	new         bool
+
+	runRe         *regexp.Regexp
+	skipRe        *regexp.Regexp
+	tagSet        map[string]bool
+	excludeTagSet map[string]bool
+	shardIndex    int
+	shardCount    int
+	lastFailed    map[string]bool // Tests which failed in the last run
+	everRun       map[string]bool // Tests which have a recorded duration
+}
+
+func (f *testFilter) addFlags(fs *flag.FlagSet) {
+	fs.StringVar(&f.run, "run", "", "only use tests whose names match this regular expression")
+	fs.StringVar(&f.skip, "skip", "", "don't use tests whose names match this regular expression")
+	fs.StringVar(&f.tags, "tags", "", "comma-separated list of tags; only use tests with at least one of them")
+	fs.StringVar(&f.excludeTags, "exclude-tags", "", "comma-separated list of tags; don't use tests with any of them")
+	fs.StringVar(&f.shard, "shard", "", "only use shard i of n (given as i/n) of the selected tests")
+	fs.BoolVar(&f.failed, "failed", false, "only use tests which failed in the last run")
+	fs.BoolVar(&f.new, "new", false, "only use tests which have never been run")
+	fs.BoolVar(&f.balance, "shard-balance", false, "balance shards using the recorded durations of earlier runs, rather than by test name")
+}
+
+// Compile the filter, once the flags have been parsed
+func (f *testFilter) compile() (err error) {
+	if f.run != "" {
+		f.runRe, err = regexp.Compile(f.run)
+		if err != nil {
+			return errors.New("Invalid -run regular expression: " + err.Error())
+		}
+	}
+	if f.skip != "" {
+		f.skipRe, err = regexp.Compile(f.skip)
+		if err != nil {
+			return errors.New("Invalid -skip regular expression: " + err.Error())
+		}
+	}
+	if f.tags != "" {
+		f.tagSet = make(map[string]bool)
+		for _, v := range splitList(f.tags) {
+			f.tagSet[v] = true
+		}
+	}
+	f.excludeTagSet = make(map[string]bool)
+	for _, v := range splitList(f.excludeTags) {
+		f.excludeTagSet[v] = true
+	}
+	if f.failed {
+		f.lastFailed = make(map[string]bool)
+		for name, state := range loadLastRun().Tests {
+			if state.Status == statusFail {
+				f.lastFailed[name] = true
+			}
+		}
+	}
+	if f.new {
+		f.everRun = make(map[string]bool)
+		for name := range loadDurations() {
+			f.everRun[name] = true
+		}
+	}
+	if f.shard != "" {
+		f.shardIndex, f.shardCount, err = parseShard(f.shard)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Report whether a test is selected by its name. A nil filter selects
+// everything.
+func (f *testFilter) matchName(name string) bool {
+	if f == nil {
+		return true
+	}
+	if f.runRe != nil && !f.runRe.MatchString(name) {
+		return false
+	}
+	if f.skipRe != nil && f.skipRe.MatchString(name) {
+		return false
+	}
+	if f.lastFailed != nil && !f.lastFailed[name] {
+		return false
+	}
+	if f.everRun != nil && f.everRun[name] {
+		return false
+	}
+	return true
+}
+
+// Report whether a test is selected by the tags in its profile
+func (f *testFilter) matchProfile(p *testProfile) bool {
+	if f == nil {
+		return true
+	}
+	tagged := f.tagSet == nil
+	for _, v := range p.Tags {
+		if f.excludeTagSet[v] {
+			return false
+		}
+		if f.tagSet[v] {
+			tagged = true
+		}
+	}
+	return tagged
+}
+
+// Narrow the selected tests down to the requested shard (if any)
+func (f *testFilter) selectShard(names []string) []string {
+	if f == nil || f.shardCount == 0 {
+		return names
+	}
+	if f.balance {
+		return balancedShard(names, loadDurations(), f.shardIndex, f.shardCount)
+	}
+	return hashShard(names, f.shardIndex, f.shardCount)
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"container/list"
+	"encoding/json"
+	"html/template"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	htmlMaxDiffRows = 2000 // Side-by-side diffs are cut off after this many rows
+)
+
+type htmlReport struct {
+	Started string
+	Elapsed string
+	Totals  string
+	Tests   []*htmlTest
+}
+
+type htmlTest struct {
+	Name     string
+	Status   string // One of the status* constants
+	Duration string
+	Profile  string // The resolved profile, as JSON
+	Steps    []htmlStep
+	Failures []string
+	Warnings []string
+	Diffs    []htmlDiff
+}
+
+type htmlStep struct {
+	Name     string
+	Commands []htmlCommand
+	Duration string
+	Stdout   string
+	Stderr   string
+	Failures []string
+}
+
+type htmlCommand struct {
+	Command    string
+	ExitStatus string
+	Duration   string
+}
+
+// A failed match or rmatch rule, shown side by side
+type htmlDiff struct {
+	Rule     string
+	Expected string
+	Actual   string
+	Message  string // Set instead of Rows when the files can't be shown
+	Rows     []htmlDiffRow
+}
+
+type htmlDiffRow struct {
+	Kind      string // same, change, del, add or gap
+	LeftLine  int    // 0 for no line on that side
+	Left      string
+	RightLine int
+	Right     string
+}
+
+func htmlDuration(d time.Duration) string {
+	return d.Round(time.Millisecond).String()
+}
+
+// Pair up the lines of a diff for showing side by side. Runs of removed and
+// added lines are shown next to each other. Unchanged lines further than the
+// configured context from a change are left out. Returns false if the files
+// differ too much to diff.
+func sideBySide(a, b []byte) (rows []htmlDiffRow, ok bool) {
+	ops, ok := diffLines(splitLines(a), splitLines(b))
+	if !ok {
+		return nil, false
+	}
+	all := make([]htmlDiffRow, 0, len(ops))
+	aLine, bLine := 0, 0
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			aLine++
+			bLine++
+			all = append(all, htmlDiffRow{"same", aLine, ops[i].line, bLine, ops[i].line})
+			i++
+			continue
+		}
+		removed := make([]string, 0)
+		added := make([]string, 0)
+		for ; i < len(ops) && ops[i].kind != ' '; i++ {
+			if ops[i].kind == '-' {
+				removed = append(removed, ops[i].line)
+			} else {
+				added = append(added, ops[i].line)
+			}
+		}
+		for k := 0; k < len(removed) || k < len(added); k++ {
+			row := htmlDiffRow{Kind: "change"}
+			if k < len(removed) {
+				aLine++
+				row.LeftLine, row.Left = aLine, removed[k]
+			} else {
+				row.Kind = "add"
+			}
+			if k < len(added) {
+				bLine++
+				row.RightLine, row.Right = bLine, added[k]
+			} else {
+				row.Kind = "del"
+			}
+			all = append(all, row)
+		}
+	}
+
+	context := *config.Diff.Context
+	keep := make([]bool, len(all))
+	for i, row := range all {
+		if row.Kind == "same" {
+			continue
+		}
+		for j := i - context; j <= i+context; j++ {
+			if j >= 0 && j < len(all) {
+				keep[j] = true
+			}
+		}
+	}
+	rows = make([]htmlDiffRow, 0)
+	for i, row := range all {
+		if len(rows) >= htmlMaxDiffRows {
+			rows = append(rows, htmlDiffRow{Kind: "gap", Left: "(diff truncated)"})
+			break
+		}
+		if keep[i] {
+			rows = append(rows, row)
+		} else if i == 0 || keep[i-1] {
+			rows = append(rows, htmlDiffRow{Kind: "gap", Left: "…", Right: "…"})
+		}
+	}
+	return rows, true
+}
+
+// For rmatch, the regular expression and the file are shown next to each
+// other, since there are no lines to pair up
+func sideBySideRegexp(re, actual []byte) (rows []htmlDiffRow) {
+	left, right := splitLines(re), splitLines(actual)
+	rows = make([]htmlDiffRow, 0)
+	for k := 0; k < len(left) || k < len(right); k++ {
+		if len(rows) >= htmlMaxDiffRows {
+			rows = append(rows, htmlDiffRow{Kind: "gap", Left: "(truncated)"})
+			break
+		}
+		row := htmlDiffRow{Kind: "change"}
+		if k < len(left) {
+			row.LeftLine, row.Left = k+1, left[k]
+		}
+		if k < len(right) {
+			row.RightLine, row.Right = k+1, right[k]
+		}
+		rows = append(rows, row)
+	}
+	return
+}
+
+func newHTMLDiff(m fileMismatch) (d htmlDiff) {
+	d.Rule, d.Expected, d.Actual = m.rule, m.expected, m.actual
+	if err := checkDiffable(m.expected, m.actual); err != nil {
+		d.Message = "Unable to show the files: " + err.Error()
+		return
+	}
+	a, err := ioutil.ReadFile(m.expected)
+	if err != nil {
+		d.Message = "Unable to read " + m.expected + ": " + err.Error()
+		return
+	}
+	b, err := ioutil.ReadFile(m.actual)
+	if err != nil {
+		d.Message = "Unable to read " + m.actual + ": " + err.Error()
+		return
+	}
+	// Show the files as they were compared
+	switch m.rule {
+	case "jsonMatch":
+		a, b = indentJSON(a), indentJSON(b)
+	case "rmatch":
+		b, err = normalize(b, m.normalize)
+		if err != nil {
+			d.Message = "Unable to normalize " + m.actual + ": " + err.Error()
+			return
+		}
+	default:
+		a, err = normalize(a, m.normalize)
+		if err == nil {
+			b, err = normalize(b, m.normalize)
+		}
+		if err != nil {
+			d.Message = "Unable to normalize " + m.expected + ", " + m.actual + ": " + err.Error()
+			return
+		}
+	}
+	if isBinary(a) || isBinary(b) {
+		d.Message = "Binary files differ at byte offset " + strconv.Itoa(firstDifference(a, b))
+		return
+	}
+	if m.rule == "rmatch" {
+		d.Rows = sideBySideRegexp(a, b)
+	} else if rows, ok := sideBySide(a, b); ok {
+		d.Rows = rows
+	} else {
+		d.Message = "Files differ in more than " + strconv.Itoa(diffMaxEdits) + " lines (not shown)"
+	}
+	return
+}
+
+func newHTMLTest(t *test) (h *htmlTest) {
+	h = new(htmlTest)
+	h.Name = t.testName
+	h.Status = t.state().Status
+	h.Duration = htmlDuration(t.results.duration)
+	profileBytes, err := json.MarshalIndent(t.rootProfile, "", "\t")
+	if err == nil {
+		h.Profile = string(profileBytes)
+	}
+	for k, step := range t.results.steps {
+		s := htmlStep{
+			Name:     step.name,
+			Duration: htmlDuration(step.duration),
+			Stdout:   step.stdout,
+			Stderr:   step.stderr,
+			Failures: step.failures,
+		}
+		if s.Name == "" {
+			s.Name = "step " + strconv.Itoa(k+1)
+		}
+		for _, v := range step.commands {
+			s.Commands = append(s.Commands, htmlCommand{v.command, v.exitStatus, htmlDuration(v.duration)})
+		}
+		h.Steps = append(h.Steps, s)
+	}
+	h.Failures = listStrings(t.results.errorList)
+	h.Warnings = listStrings(t.results.warningList)
+	for _, v := range t.results.mismatches {
+		h.Diffs = append(h.Diffs, newHTMLDiff(v))
+	}
+	return
+}
+
+func writeHTMLReport(path string, tests *list.List, started time.Time, elapsed time.Duration) error {
+	report := htmlReport{
+		Started: started.Format("2006-01-02 15:04:05"),
+		Elapsed: htmlDuration(elapsed),
+		Totals:  newSummary(tests, elapsed).totals(),
+	}
+	for e := tests.Front(); e != nil; e = e.Next() {
+		report.Tests = append(report.Tests, newHTMLTest(e.Value.(*test)))
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = htmlTemplate.Execute(f, report)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+var htmlTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"trimNewline": func(s string) string { return strings.TrimSuffix(s, "\n") },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>yoke report: {{.Started}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 0.2em 0.6em; text-align: left; vertical-align: top; }
+pre { margin: 0; white-space: pre-wrap; word-break: break-all; }
+details { margin: 0.4em 0; }
+summary { cursor: pointer; }
+section { border-top: 2px solid #ccc; margin-top: 1.5em; }
+.pass { color: #080; } .fail { color: #c00; } .skip { color: #888; } .xfail { color: #a60; }
+.failures { color: #c00; } .warnings { color: #a60; }
+table.diff { font-family: monospace; width: 100%; table-layout: fixed; }
+table.diff td { border: none; padding: 0 0.4em; }
+table.diff td.n { width: 3em; text-align: right; color: #888; }
+tr.change td.l, tr.del td.l { background: #fdd; }
+tr.change td.r, tr.add td.r { background: #dfd; }
+tr.gap td { color: #888; background: #f4f4f4; }
+</style>
+</head>
+<body>
+<h1>yoke report</h1>
+<p>Started {{.Started}}, took {{.Elapsed}}</p>
+<p>{{.Totals}}</p>
+<table>
+<tr><th>Test</th><th>Result</th><th>Time</th></tr>
+{{range .Tests}}<tr><td><a href="#{{.Name}}">{{.Name}}</a></td><td class="{{.Status}}">{{.Status}}</td><td>{{.Duration}}</td></tr>
+{{end}}</table>
+{{range .Tests}}
+<section id="{{.Name}}">
+<h2>{{.Name}} <span class="{{.Status}}">{{.Status}}</span></h2>
+<p>Took {{.Duration}}</p>
+{{if .Failures}}<div class="failures"><h3>Failures</h3>{{range .Failures}}<pre>{{.}}</pre>{{end}}</div>{{end}}
+{{if .Warnings}}<div class="warnings"><h3>Warnings</h3>{{range .Warnings}}<pre>{{.}}</pre>{{end}}</div>{{end}}
+{{range .Diffs}}
+<h3>{{.Rule}}: {{.Expected}}, {{.Actual}}</h3>
+{{if .Message}}<p>{{.Message}}</p>{{else}}
+<table class="diff">
+<tr><th class="n"></th><th>{{.Expected}}</th><th class="n"></th><th>{{.Actual}}</th></tr>
+{{range .Rows}}<tr class="{{.Kind}}"><td class="n">{{if .LeftLine}}{{.LeftLine}}{{end}}</td><td class="l"><pre>{{trimNewline .Left}}</pre></td><td class="n">{{if .RightLine}}{{.RightLine}}{{end}}</td><td class="r"><pre>{{trimNewline .Right}}</pre></td></tr>
+{{end}}</table>{{end}}
+{{end}}
+{{range .Steps}}
+<h3>{{.Name}}</h3>
+<p>Took {{.Duration}}</p>
+<table>
+<tr><th>Command</th><th>Exit status</th><th>Time</th></tr>
+{{range .Commands}}<tr><td><pre>{{.Command}}</pre></td><td>{{.ExitStatus}}</td><td>{{.Duration}}</td></tr>
+{{end}}</table>
+{{if .Failures}}<div class="failures">{{range .Failures}}<pre>{{.}}</pre>{{end}}</div>{{end}}
+<details><summary>stdout</summary><pre>{{.Stdout}}</pre></details>
+<details><summary>stderr</summary><pre>{{.Stderr}}</pre></details>
+{{end}}
+<details><summary>Resolved profile</summary><pre>{{.Profile}}</pre></details>
+</section>
+{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewHTMLDiffNormalizeError(t *testing.T) {
+	r := resultsWithFiles(t, map[string]string{"exp": "a\n", "act": "b\n"})
+	dir := *r.testName
+	for _, rule := range []string{"match", "rmatch"} {
+		d := newHTMLDiff(fileMismatch{rule, dir + "/exp", dir + "/act", []normalizeStep{{Name: "noSuchFilter"}}})
+		if !strings.Contains(d.Message, "unknown normalize filter: noSuchFilter") || d.Rows != nil {
+			t.Errorf("newHTMLDiff for %s with a bad normalize step = %q, %v", rule, d.Message, d.Rows)
+		}
+	}
+}
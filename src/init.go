@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultPrefix            = "test-"
+	defaultLimitOutput       = 1048576
+	defaultMaxTimePerCommand = 10
+	expectedSuffix           = ".expected"
+)
+
+// Filenames recognized as stdin/stdout/stderr when inferring a configuration
+var (
+	stdinNames  = []string{"input", "stdin", "in"}
+	stdoutNames = []string{"output", "stdout", "out"}
+	stderrNames = []string{"error", "stderr", "err"}
+)
+
+// Values for a new configuration file
+type initSettings struct {
+	prefix            string
+	maxthreads        int
+	command           string
+	stdin             []string
+	stdout            string
+	stderr            string
+	limitOutput       int64
+	maxTimePerCommand int64
+	zeroExit          bool
+	requiredFiles     []string
+	match             []fileRule
+	rmatch            []fileRule
+}
+
+func initConfig(args []string) error {
+	initFlags := flag.NewFlagSet("init", flag.ExitOnError)
+
+	s := initSettings{
+		prefix:            defaultPrefix,
+		stdin:             []string{"input"},
+		stdout:            "output",
+		stderr:            "error",
+		limitOutput:       defaultLimitOutput,
+		maxTimePerCommand: defaultMaxTimePerCommand,
+		zeroExit:          true,
+	}
+	var stdin string
+	var match, rmatch ruleListFlag
+	initFlags.StringVar(&s.prefix, "prefix", s.prefix, "test directory prefix")
+	initFlags.IntVar(&s.maxthreads, "maxthreads", s.maxthreads, "maximum number of concurrent tests (0 for the number of CPUs)")
+	initFlags.StringVar(&s.command, "command", s.command, "default test command")
+	initFlags.StringVar(&stdin, "stdin", strings.Join(s.stdin, ","), "comma-separated list of files to use as stdin")
+	initFlags.StringVar(&s.stdout, "stdout", s.stdout, "file to write stdout to")
+	initFlags.StringVar(&s.stderr, "stderr", s.stderr, "file to write stderr to")
+	initFlags.Int64Var(&s.limitOutput, "limitOutput", s.limitOutput, "maximum number of bytes written to stdout/stderr (0 for no limit)")
+	initFlags.Int64Var(&s.maxTimePerCommand, "maxTimePerCommand", s.maxTimePerCommand, "maximum number of seconds per command")
+	initFlags.BoolVar(&s.zeroExit, "zeroExit", s.zeroExit, "whether a zero exit status is required to pass")
+	initFlags.Var(&match, "match", "comma-separated list of files which must match (may be repeated)")
+	initFlags.Var(&rmatch, "rmatch", "comma-separated regular expression file and files which must match it (may be repeated)")
+	infer := initFlags.Bool("infer", false, "infer the configuration from existing test directories")
+	interactive := initFlags.Bool("i", false, "ask for each setting")
+	force := initFlags.Bool("force", false, "overwrite an existing configuration file")
+	initFlags.Parse(args)
+
+	if _, err := os.Stat(defaultConfigFile); err == nil && !*force {
+		return errors.New("Configuration file already exists: " + defaultConfigFile)
+	}
+
+	set := make(map[string]bool)
+	initFlags.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	if *infer {
+		inferred, err := inferSettings(s.prefix)
+		if err != nil {
+			return err
+		}
+		// Explicit flags win over inferred values
+		if !set["command"] {
+			s.command = inferred.command
+			if s.command == "" {
+				fmt.Println("No command is used by more than half of the tests, so the default command is left unset (set it with -command)")
+			}
+		}
+		if !set["stdin"] {
+			stdin = strings.Join(inferred.stdin, ",")
+		}
+		if !set["stdout"] {
+			s.stdout = inferred.stdout
+		}
+		if !set["stderr"] {
+			s.stderr = inferred.stderr
+		}
+		s.requiredFiles = inferred.requiredFiles
+		s.match = inferred.match
+		s.rmatch = inferred.rmatch
+	}
+	if set["match"] {
+		s.match = match
+	}
+	if set["rmatch"] {
+		s.rmatch = rmatch
+	}
+	s.stdin = splitList(stdin)
+
+	if *interactive {
+		askSettings(&s)
+	}
+
+	return writeConfig(&s)
+}
+
+// Infer settings from the files shared by all existing test directories.
+// Match rules are only inferred for files which already match in every
+// test, and the default command only if most of the tests use it.
+func inferSettings(prefix string) (s initSettings, err error) {
+	testFiles, _ := ioutil.ReadDir("./")
+	common := make(map[string]int)
+	matching := make(map[string]int) // foo.expected files which are the same as foo
+	commands := make(map[string]int)
+	numTests := 0
+	for _, f := range testFiles {
+		if !f.IsDir() || !strings.HasPrefix(f.Name(), prefix) {
+			continue
+		}
+		numTests++
+		files, _ := ioutil.ReadDir(f.Name())
+		for _, v := range files {
+			if v.IsDir() || v.Name() == profileFileName {
+				continue
+			}
+			common[v.Name()]++
+			if strings.HasSuffix(v.Name(), expectedSuffix) {
+				equal, err := filesEqual(f.Name()+"/"+v.Name(), f.Name()+"/"+strings.TrimSuffix(v.Name(), expectedSuffix))
+				if err == nil && equal {
+					matching[v.Name()]++
+				}
+			}
+		}
+		// Use the profiles' commands as candidates for the default command
+		profileBytes, err := ioutil.ReadFile(f.Name() + "/" + profileFileName)
+		if err == nil {
+			var p testProfile
+			if json.Unmarshal(profileBytes, &p) == nil && p.Command != nil {
+				commands[*p.Command]++
+			}
+		}
+	}
+	if numTests == 0 {
+		return s, errors.New("No test directories found with prefix: " + prefix)
+	}
+
+	shared := make([]string, 0)
+	for k, v := range common {
+		if v == numTests {
+			shared = append(shared, k)
+		}
+	}
+	sort.Strings(shared)
+	isShared := func(name string) bool {
+		i := sort.SearchStrings(shared, name)
+		return i < len(shared) && shared[i] == name
+	}
+	firstShared := func(names []string) string {
+		for _, v := range names {
+			if isShared(v) {
+				return v
+			}
+		}
+		return ""
+	}
+
+	if stdin := firstShared(stdinNames); stdin != "" {
+		s.stdin = []string{stdin}
+	}
+	s.stdout = firstShared(stdoutNames)
+	s.stderr = firstShared(stderrNames)
+
+	// Files the tests write aren't required; everything else shared is
+	s.requiredFiles = make([]string, 0)
+	for _, v := range shared {
+		if v != s.stdout && v != s.stderr {
+			s.requiredFiles = append(s.requiredFiles, v)
+		}
+	}
+
+	// Pair up foo.expected with foo
+	for _, v := range shared {
+		if strings.HasSuffix(v, expectedSuffix) && matching[v] == numTests {
+			s.match = append(s.match, fileRule{Files: []string{v, strings.TrimSuffix(v, expectedSuffix)}})
+		}
+	}
+
+	// Use the command of more than half of the tests, if there is one
+	for k, v := range commands {
+		if v*2 > numTests {
+			s.command = k
+		}
+	}
+	return
+}
+
+// Ask for each setting on stdin, using the current values as defaults
+func askSettings(s *initSettings) {
+	in := bufio.NewReader(os.Stdin)
+	ask := func(question, def string) string {
+		fmt.Printf("%s [%s]: ", question, def)
+		answer, _ := in.ReadString('\n')
+		answer = strings.TrimSpace(answer)
+		if answer == "" {
+			return def
+		}
+		return answer
+	}
+	askInt := func(question string, def int64) int64 {
+		for {
+			answer := ask(question, strconv.FormatInt(def, 10))
+			n, err := strconv.ParseInt(answer, 10, 64)
+			if err == nil {
+				return n
+			}
+			fmt.Println("Please enter a number")
+		}
+	}
+	askRules := func(question string, def []fileRule) []fileRule {
+		var rules ruleListFlag = def
+		answer := ask(question+" (space-separated list of comma-separated files, - for none)", rules.String())
+		if answer == "-" {
+			return nil
+		}
+		rules = nil
+		for _, v := range strings.Fields(answer) {
+			rules.Set(v)
+		}
+		return rules
+	}
+
+	s.prefix = ask("Test directory prefix", s.prefix)
+	s.maxthreads = int(askInt("Maximum concurrent tests (0 for the number of CPUs)", int64(s.maxthreads)))
+	s.command = ask("Default test command", s.command)
+	s.stdin = splitList(ask("Stdin files (comma-separated)", strings.Join(s.stdin, ",")))
+	s.stdout = ask("Stdout file", s.stdout)
+	s.stderr = ask("Stderr file", s.stderr)
+	s.limitOutput = askInt("Output limit in bytes (0 for no limit)", s.limitOutput)
+	s.maxTimePerCommand = askInt("Time limit per command in seconds", s.maxTimePerCommand)
+	zeroExit, err := strconv.ParseBool(ask("Require a zero exit status", strconv.FormatBool(s.zeroExit)))
+	if err == nil {
+		s.zeroExit = zeroExit
+	}
+	s.requiredFiles = splitList(ask("Required files (comma-separated)", strings.Join(s.requiredFiles, ",")))
+	s.match = askRules("Match rules", s.match)
+	s.rmatch = askRules("Regular expression match rules", s.rmatch)
+}
+
+func writeConfig(s *initSettings) error {
+	var c yokeConfig
+	c.Prefix = s.prefix
+	c.Maxthreads = s.maxthreads
+	c.Golden = defaultGolden
+	c.Diff.fixNullReferences()
+	c.Exit.fixNullReferences()
+
+	p := &c.DefaultProfile
+	name := "default"
+	noconcurrent := false
+	createRequired := true
+	p.Name = &name
+	p.Noconcurrent = &noconcurrent
+	p.CreateRequired = &createRequired
+	if s.command != "" {
+		p.Command = &s.command
+	}
+	p.LimitOutput = &s.limitOutput
+	p.MaxTimePerCommand = &s.maxTimePerCommand
+	if len(s.stdin) > 0 {
+		p.Stdin = s.stdin
+	}
+	if s.stdout != "" {
+		p.Stdout = &s.stdout
+	}
+	if s.stderr != "" {
+		p.Stderr = &s.stderr
+	}
+	if len(s.requiredFiles) > 0 {
+		p.RequiredFiles = s.requiredFiles
+	}
+	p.Pass = &passConditions{
+		ZeroExit: &s.zeroExit,
+		Match:    s.match,
+		Rmatch:   s.rmatch,
+	}
+
+	configBytes, err := json.MarshalIndent(c, "", "\t")
+	if err != nil {
+		return errors.New("Unable to marshal config JSON: " + err.Error())
+	}
+	err = ioutil.WriteFile(defaultConfigFile, append(configBytes, '\n'), 0644)
+	if err != nil {
+		return errors.New("Unable to write config file: " + err.Error())
+	}
+	fmt.Println("Configuration written: " + defaultConfigFile)
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Change to a new directory holding files, until the test finishes
+func chdirWithFiles(t *testing.T, files map[string]string) {
+	dir, err := ioutil.TempDir("", "yoke")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.Chdir(wd)
+		os.RemoveAll(dir)
+	})
+	for name, content := range files {
+		name = filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInferSettings(t *testing.T) {
+	chdirWithFiles(t, map[string]string{
+		"test-a/yoke_profile.json": `{"command": "run a"}`,
+		"test-a/input":             "1",
+		"test-a/output":            "1",
+		"test-a/output.expected":   "1",
+		"test-a/error":             "",
+		"test-a/error.expected":    "x", // Doesn't match, so there's no rule for it
+		"test-b/yoke_profile.json": `{"command": "run b"}`,
+		"test-b/input":             "2",
+		"test-b/output":            "2",
+		"test-b/output.expected":   "2",
+		"test-b/error":             "",
+		"test-b/error.expected":    "",
+	})
+	s, err := inferSettings("test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.command != "" {
+		t.Errorf("command = %q, want none, since no command is used by most tests", s.command)
+	}
+	if want := []fileRule{{Files: []string{"output.expected", "output"}}}; !reflect.DeepEqual(s.match, want) {
+		t.Errorf("match = %+v, want %+v", s.match, want)
+	}
+	if want := []string{"error.expected", "input", "output.expected"}; !reflect.DeepEqual(s.requiredFiles, want) {
+		t.Errorf("requiredFiles = %q, want %q", s.requiredFiles, want)
+	}
+	if !reflect.DeepEqual(s.stdin, []string{"input"}) || s.stdout != "output" || s.stderr != "error" {
+		t.Errorf("stdin, stdout, stderr = %q, %q, %q", s.stdin, s.stdout, s.stderr)
+	}
+
+	chdirWithFiles(t, map[string]string{
+		"test-a/yoke_profile.json": `{"command": "run"}`,
+		"test-b/yoke_profile.json": `{"command": "run"}`,
+		"test-c/yoke_profile.json": `{"command": "other"}`,
+	})
+	s, err = inferSettings("test-")
+	if err != nil || s.command != "run" {
+		t.Errorf("command = %q, %v, want the command of most tests", s.command, err)
+	}
+}
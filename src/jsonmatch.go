@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	jsonMaxDifferences = 20 // Differing paths listed in a failure message
+)
+
+// A jsonMatch rule: files which must hold the same JSON value
+type jsonRule struct {
+	Files     []string `json:"files"`
+	Ignore    []string `json:"ignore,omitempty"`    // JSON pointers to leave out of the comparison. A * segment matches any key or index (and is the only way to match an index in an unordered array).
+	Unordered bool     `json:"unordered,omitempty"` // Compare arrays regardless of the order of their elements
+	Tolerance float64  `json:"tolerance,omitempty"` // How far apart numbers may be and still be equal
+}
+
+func (j *jsonRule) UnmarshalJSON(data []byte) error {
+	type plainRule jsonRule
+	return unmarshalRule(data, "jsonMatch", &j.Files, (*plainRule)(j))
+}
+
+func (j jsonRule) MarshalJSON() ([]byte, error) {
+	type plainRule jsonRule
+	return marshalRule(j.Files, j.Ignore != nil || j.Unordered || j.Tolerance != 0, plainRule(j))
+}
+
+func (j jsonRule) String() (s string) {
+	s = strings.Join(j.Files, ", ")
+	if j.Ignore != nil {
+		s += " (ignore: " + strings.Join(j.Ignore, ", ") + ")"
+	}
+	if j.Unordered {
+		s += " (unordered)"
+	}
+	if j.Tolerance != 0 {
+		s += " (tolerance: " + strconv.FormatFloat(j.Tolerance, 'g', -1, 64) + ")"
+	}
+	return
+}
+
+// Check that the ignored paths are JSON pointers
+func (j jsonRule) check() error {
+	for _, v := range j.Ignore {
+		if v != "" && !strings.HasPrefix(v, "/") {
+			return errors.New("ignored path isn't a JSON pointer (it should start with /): " + v)
+		}
+	}
+	if j.Tolerance < 0 {
+		return errors.New("tolerance is negative")
+	}
+	return nil
+}
+
+// Escape a key for use in a JSON pointer
+func jsonPointerKey(key string) string {
+	return strings.Replace(strings.Replace(key, "~", "~0", -1), "/", "~1", -1)
+}
+
+func (j jsonRule) ignored(path string) bool {
+	segments := strings.Split(path, "/")
+	for _, v := range j.Ignore {
+		ignoreSegments := strings.Split(v, "/")
+		if len(ignoreSegments) != len(segments) {
+			continue
+		}
+		matched := true
+		for k, s := range ignoreSegments {
+			if s != "*" && s != segments[k] {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+func jsonDescribe(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	if len(b) > 80 {
+		return string(b[:77]) + "..."
+	}
+	return string(b)
+}
+
+// Compare two JSON values, adding a description of each difference (with
+// its JSON pointer path) to diffs
+func (j jsonRule) compare(path string, expected, actual interface{}, diffs *[]string) {
+	if j.ignored(path) {
+		return
+	}
+	displayPath := path
+	if displayPath == "" {
+		displayPath = "(root)"
+	}
+	switch e := expected.(type) {
+	case map[string]interface{}:
+		a, ok := actual.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := make([]string, 0, len(e)+len(a))
+		for k := range e {
+			keys = append(keys, k)
+		}
+		for k := range a {
+			if _, ok := e[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			childPath := path + "/" + jsonPointerKey(k)
+			ev, inExpected := e[k]
+			av, inActual := a[k]
+			switch {
+			case j.ignored(childPath):
+			case !inActual:
+				*diffs = append(*diffs, childPath+": missing (expected "+jsonDescribe(ev)+")")
+			case !inExpected:
+				*diffs = append(*diffs, childPath+": unexpected "+jsonDescribe(av))
+			default:
+				j.compare(childPath, ev, av, diffs)
+			}
+		}
+		return
+	case []interface{}:
+		a, ok := actual.([]interface{})
+		if !ok {
+			break
+		}
+		if j.Unordered {
+			j.compareUnordered(path, e, a, diffs)
+			return
+		}
+		for k := 0; k < len(e) || k < len(a); k++ {
+			childPath := path + "/" + strconv.Itoa(k)
+			switch {
+			case j.ignored(childPath):
+			case k >= len(a):
+				*diffs = append(*diffs, childPath+": missing (expected "+jsonDescribe(e[k])+")")
+			case k >= len(e):
+				*diffs = append(*diffs, childPath+": unexpected "+jsonDescribe(a[k]))
+			default:
+				j.compare(childPath, e[k], a[k], diffs)
+			}
+		}
+		return
+	case json.Number:
+		a, ok := actual.(json.Number)
+		if !ok {
+			break
+		}
+		if j.numbersEqual(e, a) {
+			return
+		}
+		*diffs = append(*diffs, displayPath+": expected "+e.String()+", got "+a.String())
+		return
+	default:
+		if expected == actual {
+			return
+		}
+	}
+	*diffs = append(*diffs, displayPath+": expected "+jsonDescribe(expected)+", got "+jsonDescribe(actual))
+}
+
+// Numbers are compared exactly, unless there's a tolerance. (As float64s,
+// large integers such as IDs would be rounded to the same value.)
+func (j jsonRule) numbersEqual(e, a json.Number) bool {
+	if j.Tolerance == 0 {
+		er, ok1 := new(big.Rat).SetString(e.String())
+		ar, ok2 := new(big.Rat).SetString(a.String())
+		if ok1 && ok2 {
+			return er.Cmp(ar) == 0
+		}
+		return e == a
+	}
+	ef, err1 := e.Float64()
+	af, err2 := a.Float64()
+	return err1 == nil && err2 == nil && math.Abs(ef-af) <= j.Tolerance
+}
+
+// Match the elements of two arrays regardless of order, pairing up as many
+// equal elements as possible. Since elements within the tolerance of each
+// other aren't necessarily equal to each other's matches, the first match
+// isn't always the right one, so the pairs are found as a maximum bipartite
+// matching.
+//
+// Elements don't have a fixed index here, so ignored paths only apply to
+// their index segments through *: "/a/*/id" ignores the ids of the elements
+// of an unordered array /a, but "/a/0" and "/a/0/id" have no effect.
+func (j jsonRule) compareUnordered(path string, expected, actual []interface{}, diffs *[]string) {
+	if j.ignored(path + "/*") {
+		return
+	}
+	equal := make([][]bool, len(expected))
+	for k, ev := range expected {
+		equal[k] = make([]bool, len(actual))
+		for l, av := range actual {
+			var elementDiffs []string
+			j.compare(path+"/*", ev, av, &elementDiffs)
+			equal[k][l] = len(elementDiffs) == 0
+		}
+	}
+
+	// Kuhn's algorithm: find an augmenting path from each expected element
+	pairedWith := make([]int, len(actual)) // Index of the expected element, or -1
+	for l := range pairedWith {
+		pairedWith[l] = -1
+	}
+	var augment func(k int, visited []bool) bool
+	augment = func(k int, visited []bool) bool {
+		for l := range actual {
+			if !equal[k][l] || visited[l] {
+				continue
+			}
+			visited[l] = true
+			if pairedWith[l] < 0 || augment(pairedWith[l], visited) {
+				pairedWith[l] = k
+				return true
+			}
+		}
+		return false
+	}
+	paired := make([]bool, len(expected))
+	for k := range expected {
+		paired[k] = augment(k, make([]bool, len(actual)))
+	}
+
+	for k, ev := range expected {
+		if !paired[k] {
+			*diffs = append(*diffs, path+"/"+strconv.Itoa(k)+": no matching element (expected "+jsonDescribe(ev)+")")
+		}
+	}
+	for l, av := range actual {
+		if pairedWith[l] < 0 {
+			*diffs = append(*diffs, path+"/"+strconv.Itoa(l)+": unexpected "+jsonDescribe(av))
+		}
+	}
+}
+
+func parseJSONFile(filename string) (v interface{}, err error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	err = dec.Decode(&v)
+	if err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, errors.New("more than one JSON value")
+	}
+	return v, nil
+}
+
+// Reformat a JSON file for showing in a diff, with sorted keys and one
+// value per line. Files which aren't JSON are left alone.
+func indentJSON(data []byte) []byte {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if dec.Decode(&v) != nil {
+		return data
+	}
+	b, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		return data
+	}
+	return append(b, '\n')
+}
+
+func (r *testResults) jsonMatch(index int, rule jsonRule) {
+	if err := rule.check(); err != nil {
+		r.configFail("Invalid jsonMatch rule (" + strconv.Itoa(index) + "): " + err.Error())
+		return
+	}
+	r.compareFiles("jsonMatch", index, rule.Files, parseJSONFile, func(aName, bName string, a, b interface{}) {
+		var diffs []string
+		rule.compare("", a, b, &diffs)
+		if len(diffs) == 0 {
+			r.info("JSON files match: " + aName + ", " + bName)
+			return
+		}
+		if len(diffs) > jsonMaxDifferences {
+			diffs = append(diffs[:jsonMaxDifferences], "... and "+strconv.Itoa(len(diffs)-jsonMaxDifferences)+" more")
+		}
+		aText, _ := ioutil.ReadFile(aName)
+		bText, _ := ioutil.ReadFile(bName)
+		r.diffs = append(r.diffs, unifiedDiff(aName, bName, indentJSON(aText), indentJSON(bText)))
+		r.mismatches = append(r.mismatches, fileMismatch{rule: "jsonMatch", expected: aName, actual: bName})
+		r.fail("JSON files don't match: " + aName + ", " + bName + "\n" + strings.Join(diffs, "\n"))
+	})
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func decodeJSON(t *testing.T, s string) (v interface{}) {
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		t.Fatalf("Decode(%s): %v", s, err)
+	}
+	return
+}
+
+func TestJSONCompare(t *testing.T) {
+	tests := []struct {
+		rule             jsonRule
+		expected, actual string
+		diffs            []string
+	}{
+		{jsonRule{}, `{"a": 1, "b": [1, 2]}`, `{"b": [1, 2], "a": 1}`, nil},
+		{jsonRule{}, `{"a": 1, "b": [1, 2]}`, `{"a": 1, "b": [1, 3]}`, []string{"/b/1: expected 2, got 3"}},
+		{jsonRule{}, `{"a": 1}`, `{"b": 1}`, []string{`/a: missing (expected 1)`, `/b: unexpected 1`}},
+		{jsonRule{}, `[1]`, `"1"`, []string{`(root): expected [1], got "1"`}},
+		{jsonRule{}, `{"a/b": 1, "c~d": 2}`, `{"a/b": 0, "c~d": 2}`, []string{"/a~1b: expected 1, got 0"}},
+
+		// Numbers are exact without a tolerance, even past float64 precision
+		{jsonRule{}, `{"id": 9007199254740993}`, `{"id": 9007199254740992}`, []string{"/id: expected 9007199254740993, got 9007199254740992"}},
+		{jsonRule{}, `[1, 100, 0.5]`, `[1.0, 1e2, 0.50]`, nil},
+		{jsonRule{Tolerance: 0.01}, `[1.0]`, `[1.005]`, nil},
+		{jsonRule{Tolerance: 0.01}, `[1.0]`, `[1.02]`, []string{"/0: expected 1.0, got 1.02"}},
+
+		{jsonRule{Ignore: []string{"/time", "/items/*/id"}}, `{"time": 1, "items": [{"id": 1, "v": 2}]}`, `{"time": 2, "items": [{"id": 5, "v": 2}]}`, nil},
+		{jsonRule{Ignore: []string{"/items/1"}}, `{"items": [1, 2]}`, `{"items": [1]}`, nil},
+	}
+	for _, tt := range tests {
+		var diffs []string
+		tt.rule.compare("", decodeJSON(t, tt.expected), decodeJSON(t, tt.actual), &diffs)
+		if !reflect.DeepEqual(diffs, tt.diffs) {
+			t.Errorf("%v compare(%s, %s) = %q, want %q", tt.rule, tt.expected, tt.actual, diffs, tt.diffs)
+		}
+	}
+}
+
+func TestJSONCompareUnordered(t *testing.T) {
+	tests := []struct {
+		rule             jsonRule
+		expected, actual string
+		diffs            []string
+	}{
+		{jsonRule{Unordered: true}, `[1, 2, 3]`, `[3, 1, 2]`, nil},
+		{jsonRule{Unordered: true}, `[1, 1, 2]`, `[1, 2, 2]`, []string{"/1: no matching element (expected 1)", "/2: unexpected 2"}},
+		{jsonRule{Unordered: true}, `[{"a": 1}, {"a": 2}]`, `[{"a": 2}, {"a": 1}]`, nil},
+
+		// The first match for 1.0 is 0.6, but then 0.5 would have no match
+		{jsonRule{Unordered: true, Tolerance: 0.5}, `[1.0, 0.5]`, `[0.6, 1.4]`, nil},
+		{jsonRule{Unordered: true, Tolerance: 0.5}, `[1.0, 0.5]`, `[0.6, 2.0]`, []string{"/1: no matching element (expected 0.5)", "/1: unexpected 2.0"}},
+
+		// Indexes don't mean anything in unordered arrays, so only * ignores
+		// anything inside them
+		{jsonRule{Unordered: true, Ignore: []string{"/*/id"}}, `[{"id": 1, "v": "x"}, {"id": 2, "v": "y"}]`, `[{"id": 7, "v": "y"}, {"id": 8, "v": "x"}]`, nil},
+		{jsonRule{Unordered: true, Ignore: []string{"/0/id"}}, `[{"id": 1, "v": "x"}]`, `[{"id": 2, "v": "x"}]`, []string{`/0: no matching element (expected {"id":1,"v":"x"})`, `/0: unexpected {"id":2,"v":"x"}`}},
+		{jsonRule{Unordered: true, Ignore: []string{"/1"}}, `[1, 2]`, `[2]`, []string{"/0: no matching element (expected 1)"}},
+	}
+	for _, tt := range tests {
+		var diffs []string
+		tt.rule.compare("", decodeJSON(t, tt.expected), decodeJSON(t, tt.actual), &diffs)
+		if !reflect.DeepEqual(diffs, tt.diffs) {
+			t.Errorf("%v compare(%s, %s) = %q, want %q", tt.rule, tt.expected, tt.actual, diffs, tt.diffs)
+		}
+	}
+}
+
+func TestJSONMatch(t *testing.T) {
+	r := resultsWithFiles(t, map[string]string{"exp": `{"a": [1, 2]}`, "act": "{\"a\":\n[1, 2]}\n", "bad": `{"a": [2, 1]}`, "broken": `{"a": `})
+	r.jsonMatch(0, jsonRule{Files: []string{"exp", "act"}})
+	if !r.passed {
+		t.Errorf("jsonMatch of equal JSON failed: %s", failures(r))
+	}
+	r.jsonMatch(0, jsonRule{Files: []string{"exp", "bad"}})
+	if r.passed || !strings.Contains(failures(r), "/a/0: expected 1, got 2") || len(r.mismatches) != 1 {
+		t.Errorf("jsonMatch of different JSON = %q", failures(r))
+	}
+	r.jsonMatch(0, jsonRule{Files: []string{"exp", "broken"}})
+	if !strings.Contains(failures(r), "broken") {
+		t.Errorf("jsonMatch of a file which isn't JSON = %q", failures(r))
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"container/list"
+	"encoding/xml"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+// The run. Each step of each test (the test itself, then its next steps) is
+// described by the properties.
+type junitTestSuite struct {
+	Name       string           `xml:"name,attr"`
+	Tests      int              `xml:"tests,attr"`
+	Failures   int              `xml:"failures,attr"`
+	Skipped    int              `xml:"skipped,attr"`
+	Time       string           `xml:"time,attr"`
+	Timestamp  string           `xml:"timestamp,attr"`
+	Properties *junitProperties `xml:"properties,omitempty"`
+	Cases      []junitTestCase  `xml:"testcase"`
+}
+
+// One test directory, with an entry for each step in its failures and
+// output
+type junitTestCase struct {
+	Name      string         `xml:"name,attr"`
+	Classname string         `xml:"classname,attr"`
+	Time      string         `xml:"time,attr"`
+	Skipped   *junitMessage  `xml:"skipped,omitempty"`
+	Failures  []junitMessage `xml:"failure"`
+	SystemOut []string       `xml:"system-out"`
+	SystemErr []string       `xml:"system-err"`
+}
+
+type junitProperties struct {
+	Properties []junitProperty `xml:"property"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr,omitempty"`
+	Type    string `xml:"type,attr,omitempty"`
+	Body    string `xml:",chardata"`
+}
+
+func junitSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
+
+// A failure, with the first line of the first message as its message
+func junitFailure(prefix string, failures []string) junitMessage {
+	if len(failures) == 0 {
+		return junitMessage{Message: prefix + "Test failed", Type: "failure"}
+	}
+	return junitMessage{
+		Message: prefix + strings.SplitN(failures[0], "\n", 2)[0],
+		Type:    "failure",
+		Body:    strings.Join(failures, "\n"),
+	}
+}
+
+// How a step is named in the report: step 2 (simple-next)
+func junitStepName(k int, step *stepResult) string {
+	if step.name == "" {
+		return "step " + strconv.Itoa(k+1)
+	}
+	return "step " + strconv.Itoa(k+1) + " (" + step.name + ")"
+}
+
+// The test case for a test, and the properties of its steps
+func junitCase(t *test) (c junitTestCase, properties []junitProperty) {
+	c.Name = t.testName
+	c.Classname = "yoke"
+	c.Time = junitSeconds(t.results.duration)
+
+	var stepFailures []junitMessage
+	for k, step := range t.results.steps {
+		stepName := junitStepName(k, step)
+		prefix := t.testName + " step " + strconv.Itoa(k+1) + " "
+		result := "passed"
+		if len(step.failures) > 0 {
+			result = "failed"
+			stepFailures = append(stepFailures, junitFailure(stepName+": ", step.failures))
+		}
+		commands := make([]string, 0, len(step.commands))
+		for _, v := range step.commands {
+			commands = append(commands, v.command)
+		}
+		properties = append(properties,
+			junitProperty{prefix + "name", step.name},
+			junitProperty{prefix + "commands", strings.Join(commands, "\n")},
+			junitProperty{prefix + "exit status", step.exitStatus},
+			junitProperty{prefix + "time", junitSeconds(step.duration)},
+			junitProperty{prefix + "result", result})
+		if step.stdout != "" {
+			c.SystemOut = append(c.SystemOut, "=== "+stepName+" stdout\n"+step.stdout)
+		}
+		if step.stderr != "" {
+			c.SystemErr = append(c.SystemErr, "=== "+stepName+" stderr\n"+step.stderr)
+		}
+	}
+
+	switch {
+	case t.results.skipped:
+		c.Skipped = &junitMessage{Message: "Test skipped"}
+	case t.results.expectedFailure:
+		c.Skipped = &junitMessage{Message: "Expected failure", Body: strings.Join(listStrings(t.results.errorList), "\n")}
+	case t.results.passed:
+	case len(stepFailures) > 0:
+		c.Failures = stepFailures
+	default:
+		// Failures which don't belong to any step
+		c.Failures = []junitMessage{junitFailure("", listStrings(t.results.errorList))}
+	}
+
+	warnings := listStrings(t.results.warningList)
+	if len(warnings) > 0 {
+		c.SystemErr = append([]string{"=== warnings\n" + strings.Join(warnings, "\n")}, c.SystemErr...)
+	}
+	return
+}
+
+func writeJUnitReport(path string, tests *list.List, started time.Time, elapsed time.Duration) error {
+	suite := junitTestSuite{
+		Name:      "yoke",
+		Time:      junitSeconds(elapsed),
+		Timestamp: started.Format("2006-01-02T15:04:05"),
+	}
+	for e := tests.Front(); e != nil; e = e.Next() {
+		c, properties := junitCase(e.Value.(*test))
+		suite.Tests++
+		if c.Skipped != nil {
+			suite.Skipped++
+		}
+		if len(c.Failures) > 0 {
+			suite.Failures++
+		}
+		suite.Cases = append(suite.Cases, c)
+		if len(properties) > 0 {
+			if suite.Properties == nil {
+				suite.Properties = new(junitProperties)
+			}
+			suite.Properties.Properties = append(suite.Properties.Properties, properties...)
+		}
+	}
+	suites := junitTestSuites{
+		Name:     suite.Name,
+		Tests:    suite.Tests,
+		Failures: suite.Failures,
+		Skipped:  suite.Skipped,
+		Time:     suite.Time,
+		Suites:   []junitTestSuite{suite},
+	}
+
+	reportBytes, err := xml.MarshalIndent(suites, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append([]byte(xml.Header), append(reportBytes, '\n')...), 0644)
+}
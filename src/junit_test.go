@@ -0,0 +1,59 @@
+package main
+
+import (
+	"container/list"
+	"encoding/xml"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestJUnitReport(t *testing.T) {
+	passing := &test{testName: "test-a", results: newResults()}
+	passing.results.passed = true
+	passing.results.steps = []*stepResult{{name: "a", commands: []commandResult{{command: "true"}}, exitStatus: "exit status 0", stdout: "out"}}
+
+	failing := &test{testName: "test-b", results: newResults()}
+	failing.results.passed = false
+	failing.results.steps = []*stepResult{
+		{name: "b", exitStatus: "exit status 0"},
+		{exitStatus: "exit status 1", failures: []string{"Files don't match: x, y\n-x\n+y"}},
+	}
+
+	tests := list.New()
+	tests.PushBack(passing)
+	tests.PushBack(failing)
+	path := filepath.Join(t.TempDir(), "report.xml")
+	if err := writeJUnitReport(path, tests, time.Now(), time.Second); err != nil {
+		t.Fatal(err)
+	}
+	reportBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report junitTestSuites
+	if err := xml.Unmarshal(reportBytes, &report); err != nil {
+		t.Fatal(err)
+	}
+
+	// A test case per test directory, not per step
+	if report.Tests != 2 || report.Failures != 1 || len(report.Suites) != 1 {
+		t.Fatalf("report totals = %d tests, %d failures, %d suites", report.Tests, report.Failures, len(report.Suites))
+	}
+	cases := report.Suites[0].Cases
+	if len(cases) != 2 || cases[0].Name != "test-a" || len(cases[0].Failures) != 0 || !reflect.DeepEqual(cases[0].SystemOut, []string{"=== step 1 (a) stdout\nout"}) {
+		t.Errorf("passing test case = %+v", cases[0])
+	}
+	want := []junitMessage{{Message: "step 2: Files don't match: x, y", Type: "failure", Body: "Files don't match: x, y\n-x\n+y"}}
+	if len(cases) != 2 || !reflect.DeepEqual(cases[1].Failures, want) {
+		t.Errorf("failing test case failures = %+v, want %+v", cases[1].Failures, want)
+	}
+
+	// The steps are described by the properties of the suite
+	properties := report.Suites[0].Properties
+	if properties == nil || len(properties.Properties) != 15 || properties.Properties[1] != (junitProperty{"test-a step 1 commands", "true"}) {
+		t.Errorf("suite properties = %+v", properties)
+	}
+}
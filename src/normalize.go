@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Named normalization filters
+const (
+	normalizeTrimTrailingWhitespace = "trimTrailingWhitespace"
+	normalizeUnifyLineEndings       = "unifyLineEndings" // CRLF and CR become LF
+	normalizeIgnoreCase             = "ignoreCase"
+	normalizeCollapseBlankLines     = "collapseBlankLines" // Runs of blank lines become one empty line
+	normalizeSortLines              = "sortLines"
+)
+
+var normalizeNames = []string{
+	normalizeTrimTrailingWhitespace,
+	normalizeUnifyLineEndings,
+	normalizeIgnoreCase,
+	normalizeCollapseBlankLines,
+	normalizeSortLines,
+}
+
+// A step of a rule's normalize pipeline: either one of the named filters,
+// given as a string, or a regular expression replacement, given as an
+// object (e.g., {"replace": "pid [0-9]+", "with": "pid N"})
+type normalizeStep struct {
+	Name    string         `json:"-"`
+	Replace string         `json:"replace"`
+	With    string         `json:"with"` // May use $1 etc. for submatches
+	re      *regexp.Regexp // Replace, compiled when the step is loaded
+}
+
+// Replacements are compiled here, so it's only done once for every file
+// they're applied to. Regular expressions which don't compile are reported
+// by check.
+func (n *normalizeStep) UnmarshalJSON(data []byte) error {
+	if json.Unmarshal(data, &n.Name) == nil {
+		return nil
+	}
+	type plainStep normalizeStep // Without these methods
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	err := dec.Decode((*plainStep)(n))
+	if err != nil {
+		return errors.New("normalize step must be a filter name or an object with replace and with: " + err.Error())
+	}
+	n.re, _ = regexp.Compile(n.Replace)
+	return nil
+}
+
+// The compiled regular expression of a replacement. Steps which weren't
+// loaded from JSON are compiled now.
+func (n normalizeStep) regexp() (*regexp.Regexp, error) {
+	if n.re != nil {
+		return n.re, nil
+	}
+	return regexp.Compile(n.Replace)
+}
+
+func (n normalizeStep) MarshalJSON() ([]byte, error) {
+	if n.Name != "" {
+		return json.Marshal(n.Name)
+	}
+	type plainStep normalizeStep
+	return json.Marshal(plainStep(n))
+}
+
+func (n normalizeStep) String() string {
+	if n.Name != "" {
+		return n.Name
+	}
+	return "replace " + n.Replace + " with " + n.With
+}
+
+// Check that a step can be applied
+func (n normalizeStep) check() error {
+	if n.Name == "" {
+		if n.Replace == "" {
+			return errors.New("normalize replacement has no regular expression")
+		}
+		_, err := n.regexp()
+		if err != nil {
+			return errors.New("normalize regular expression doesn't compile: " + n.Replace + ": " + err.Error())
+		}
+		return nil
+	}
+	for _, v := range normalizeNames {
+		if n.Name == v {
+			return nil
+		}
+	}
+	return errors.New("unknown normalize filter: " + n.Name + " (expected one of: " + strings.Join(normalizeNames, ", ") + ")")
+}
+
+// Apply each line of text to f, keeping the line endings
+func mapLines(text []byte, f func(line []byte) []byte) []byte {
+	var buf bytes.Buffer
+	for _, line := range splitLines(text) {
+		body := strings.TrimSuffix(line, "\n")
+		buf.Write(f([]byte(body)))
+		if len(body) < len(line) {
+			buf.WriteByte('\n')
+		}
+	}
+	return buf.Bytes()
+}
+
+// Read two files and run them both through a normalize pipeline
+func readNormalized(aName, bName string, steps []normalizeStep) (a, b []byte, err error) {
+	a, err = ioutil.ReadFile(aName)
+	if err == nil {
+		b, err = ioutil.ReadFile(bName)
+	}
+	if err == nil {
+		a, err = normalize(a, steps)
+	}
+	if err == nil {
+		b, err = normalize(b, steps)
+	}
+	return
+}
+
+// Run text through a normalize pipeline
+func normalize(text []byte, steps []normalizeStep) ([]byte, error) {
+	for _, step := range steps {
+		if err := step.check(); err != nil {
+			return nil, err
+		}
+		switch step.Name {
+		case "":
+			re, _ := step.regexp() // Checked above
+			text = re.ReplaceAll(text, []byte(step.With))
+		case normalizeTrimTrailingWhitespace:
+			text = mapLines(text, func(line []byte) []byte {
+				return bytes.TrimRight(line, " \t\r\f\v")
+			})
+		case normalizeUnifyLineEndings:
+			text = bytes.Replace(text, []byte("\r\n"), []byte("\n"), -1)
+			text = bytes.Replace(text, []byte("\r"), []byte("\n"), -1)
+		case normalizeIgnoreCase:
+			text = bytes.ToLower(text)
+		case normalizeCollapseBlankLines:
+			var buf bytes.Buffer
+			blank := false
+			for _, line := range splitLines(text) {
+				if strings.TrimSpace(line) == "" {
+					if blank {
+						continue
+					}
+					blank = true
+					if strings.HasSuffix(line, "\n") {
+						line = "\n"
+					} else {
+						line = ""
+					}
+				} else {
+					blank = false
+				}
+				buf.WriteString(line)
+			}
+			text = buf.Bytes()
+		case normalizeSortLines:
+			lines := splitLines(text)
+			// The last line may be missing its newline, which shouldn't
+			// affect where it's sorted to
+			newline := len(lines) > 0 && strings.HasSuffix(lines[len(lines)-1], "\n")
+			for k, v := range lines {
+				lines[k] = strings.TrimSuffix(v, "\n")
+			}
+			sort.Strings(lines)
+			joined := strings.Join(lines, "\n")
+			if newline {
+				joined += "\n"
+			}
+			text = []byte(joined)
+		}
+	}
+	return text, nil
+}
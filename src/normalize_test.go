@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	cases := []struct {
+		steps string // As given in a profile
+		input string
+		want  string
+	}{
+		{`["trimTrailingWhitespace"]`, "a  \nb\t\r\nc ", "a\nb\nc"},
+		{`["unifyLineEndings"]`, "a\r\nb\rc\n", "a\nb\nc\n"},
+		{`["ignoreCase"]`, "AbC\nDEF", "abc\ndef"},
+		{`["collapseBlankLines"]`, "a\n\n\n  \nb\n\n", "a\n\nb\n\n"},
+		{`["collapseBlankLines"]`, "a\n\n \t", "a\n\n"},
+		{`["sortLines"]`, "b\nc\na", "a\nb\nc"},
+		{`["sortLines"]`, "b\na\n", "a\nb\n"},
+		{`[{"replace": "pid [0-9]+", "with": "pid N"}]`, "pid 123, pid 4\n", "pid N, pid N\n"},
+		{`[{"replace": "(\\w+)@(\\w+)", "with": "$2@$1"}]`, "a@b\n", "b@a\n"},
+		{`["unifyLineEndings", "trimTrailingWhitespace", "sortLines"]`, "b \r\na\r\n", "a\nb\n"},
+		{`[]`, "as is\n", "as is\n"},
+	}
+	for _, c := range cases {
+		var steps []normalizeStep
+		if err := json.Unmarshal([]byte(c.steps), &steps); err != nil {
+			t.Fatalf("Unmarshal(%s): %v", c.steps, err)
+		}
+		got, err := normalize([]byte(c.input), steps)
+		if err != nil || string(got) != c.want {
+			t.Errorf("normalize(%q, %s) = %q, %v; want %q", c.input, c.steps, got, err, c.want)
+		}
+	}
+}
+
+func TestNormalizeErrors(t *testing.T) {
+	cases := []struct {
+		steps string
+		want  string
+	}{
+		{`["noSuchFilter"]`, "unknown normalize filter: noSuchFilter"},
+		{`[{"replace": "", "with": "x"}]`, "normalize replacement has no regular expression"},
+		{`[{"replace": "(", "with": "x"}]`, "normalize regular expression doesn't compile: ("},
+	}
+	for _, c := range cases {
+		var steps []normalizeStep
+		if err := json.Unmarshal([]byte(c.steps), &steps); err != nil {
+			t.Fatalf("Unmarshal(%s): %v", c.steps, err)
+		}
+		_, err := normalize([]byte("text\n"), steps)
+		if err == nil || !strings.HasPrefix(err.Error(), c.want) {
+			t.Errorf("normalize with %s: error %v, want %q", c.steps, err, c.want)
+		}
+	}
+}
+
+func TestNormalizeStepCompiledOnLoad(t *testing.T) {
+	var step normalizeStep
+	if err := json.Unmarshal([]byte(`{"replace": "[0-9]+", "with": "N"}`), &step); err != nil {
+		t.Fatal(err)
+	}
+	if step.re == nil || step.re.String() != "[0-9]+" {
+		t.Errorf("replace wasn't compiled when loaded: %v", step.re)
+	}
+
+	// Steps which weren't loaded still work
+	got, err := normalize([]byte("a1b22"), []normalizeStep{{Replace: "[0-9]+", With: "N"}})
+	if err != nil || string(got) != "aNbN" {
+		t.Errorf("normalize with an uncompiled step = %q, %v", got, err)
+	}
+}
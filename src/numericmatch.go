@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var numberRe = regexp.MustCompile(`[-+]?(?:[0-9]+(?:\.[0-9]*)?|\.[0-9]+)(?:[eE][-+]?[0-9]+)?`)
+
+// A numericMatch rule: files which must match, except that numbers only
+// have to be within the tolerances. Numbers are equal if they're within
+// either tolerance.
+type numericRule struct {
+	Files []string `json:"files"`
+	Abs   float64  `json:"abs,omitempty"` // Absolute tolerance
+	Rel   float64  `json:"rel,omitempty"` // Relative tolerance, as a fraction of the larger number
+}
+
+func (n *numericRule) UnmarshalJSON(data []byte) error {
+	type plainRule numericRule
+	return unmarshalRule(data, "numericMatch", &n.Files, (*plainRule)(n))
+}
+
+func (n numericRule) MarshalJSON() ([]byte, error) {
+	type plainRule numericRule
+	return marshalRule(n.Files, n.Abs != 0 || n.Rel != 0, plainRule(n))
+}
+
+func (n numericRule) tolerances() string {
+	return "abs " + strconv.FormatFloat(n.Abs, 'g', -1, 64) + ", rel " + strconv.FormatFloat(n.Rel, 'g', -1, 64)
+}
+
+func (n numericRule) String() string {
+	return strings.Join(n.Files, ", ") + " (tolerance: " + n.tolerances() + ")"
+}
+
+func (n numericRule) check() error {
+	if n.Abs < 0 || n.Rel < 0 {
+		return errors.New("tolerances can't be negative")
+	}
+	return nil
+}
+
+// Allowance for rounding, so a difference which is printed the same as the
+// tolerance is within it
+const toleranceSlack = 1 + 1e-9
+
+func (n numericRule) equal(a, b float64) bool {
+	diff := math.Abs(a - b)
+	return a == b || diff <= n.Abs*toleranceSlack || diff <= n.Rel*math.Max(math.Abs(a), math.Abs(b))*toleranceSlack
+}
+
+// A number, or a run of other characters without whitespace
+type numericToken struct {
+	text   string
+	number bool
+	value  float64
+	line   int
+	col    int // In characters, starting at 1
+}
+
+func (t *numericToken) position() string {
+	return "Line " + strconv.Itoa(t.line) + ", column " + strconv.Itoa(t.col)
+}
+
+// Split text into numbers and words. Whitespace only separates tokens.
+func numericTokens(text []byte) (tokens []numericToken) {
+	for lineIndex, line := range strings.Split(string(text), "\n") {
+		addWords := func(start, end int) {
+			wordStart := -1
+			for i, c := range line[start:end] {
+				if unicode.IsSpace(c) {
+					if wordStart >= 0 {
+						tokens = append(tokens, numericToken{text: line[wordStart : start+i], line: lineIndex + 1, col: utf8.RuneCountInString(line[:wordStart]) + 1})
+						wordStart = -1
+					}
+				} else if wordStart < 0 {
+					wordStart = start + i
+				}
+			}
+			if wordStart >= 0 {
+				tokens = append(tokens, numericToken{text: line[wordStart:end], line: lineIndex + 1, col: utf8.RuneCountInString(line[:wordStart]) + 1})
+			}
+		}
+		pos := 0
+		for _, loc := range numberRe.FindAllStringIndex(line, -1) {
+			addWords(pos, loc[0])
+			value, err := strconv.ParseFloat(line[loc[0]:loc[1]], 64)
+			tokens = append(tokens, numericToken{
+				text:   line[loc[0]:loc[1]],
+				number: err == nil,
+				value:  value,
+				line:   lineIndex + 1,
+				col:    utf8.RuneCountInString(line[:loc[0]]) + 1,
+			})
+			pos = loc[1]
+		}
+		addWords(pos, len(line))
+	}
+	return
+}
+
+// Find the first token of actual which doesn't match expected. Returns an
+// empty string if they match.
+func (n numericRule) firstDifference(expected, actual []numericToken) string {
+	for k := 0; k < len(expected) || k < len(actual); k++ {
+		switch {
+		case k >= len(actual):
+			return "Expected " + strconv.Quote(expected[k].text) + " (" + strings.ToLower(expected[k].position()) + " of the expected file), but the file ended"
+		case k >= len(expected):
+			return actual[k].position() + ": expected the end of the file, got " + strconv.Quote(actual[k].text)
+		case expected[k].number && actual[k].number:
+			if !n.equal(expected[k].value, actual[k].value) {
+				return actual[k].position() + ": " + actual[k].text + " is out of tolerance (expected " + expected[k].text +
+					", difference " + strconv.FormatFloat(math.Abs(expected[k].value-actual[k].value), 'g', 6, 64) + ", tolerance " + n.tolerances() + ")"
+			}
+		case expected[k].text != actual[k].text:
+			return actual[k].position() + ": expected " + strconv.Quote(expected[k].text) + ", got " + strconv.Quote(actual[k].text)
+		}
+	}
+	return ""
+}
+
+func (r *testResults) numericMatch(index int, rule numericRule) {
+	if err := rule.check(); err != nil {
+		r.configFail("Invalid numericMatch rule (" + strconv.Itoa(index) + "): " + err.Error())
+		return
+	}
+	r.compareFiles("numericMatch", index, rule.Files, readFileContent, func(aName, bName string, a, b interface{}) {
+		aText, bText := a.([]byte), b.([]byte)
+		msg := rule.firstDifference(numericTokens(aText), numericTokens(bText))
+		if msg == "" {
+			r.info("Files match (within tolerance): " + aName + ", " + bName)
+			return
+		}
+		r.diffs = append(r.diffs, unifiedDiff(aName, bName, aText, bText))
+		r.mismatches = append(r.mismatches, fileMismatch{rule: "numericMatch", expected: aName, actual: bName})
+		r.fail("Files don't match (within tolerance): " + aName + ", " + bName + "\n" + msg)
+	})
+}
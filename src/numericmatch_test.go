@@ -0,0 +1,89 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNumericEqual(t *testing.T) {
+	tests := []struct {
+		rule  numericRule
+		a, b  float64
+		equal bool
+	}{
+		{numericRule{}, 1, 1, true},
+		{numericRule{}, 1, 1.0000001, false},
+		{numericRule{Abs: 0.1}, 1.0, 1.1, true}, // 1.1-1.0 is a little over 0.1 as a float64, within the slack
+		{numericRule{Abs: 0.1}, 1.0, 1.2, false},
+		{numericRule{Abs: 0.1}, -0.05, 0.05, true},
+		{numericRule{Rel: 0.01}, 100, 101, true}, // Relative to the larger number
+		{numericRule{Rel: 0.01}, 100, 102, false},
+		{numericRule{Rel: 0.01}, 0, 0.001, false},
+		{numericRule{Abs: 0.5, Rel: 0.01}, 100, 100.4, true}, // Within either tolerance
+		{numericRule{Abs: 0.5, Rel: 0.01}, 1000, 1009, true},
+		{numericRule{Abs: 0.5, Rel: 0.01}, 10, 10.6, false},
+	}
+	for _, tt := range tests {
+		if equal := tt.rule.equal(tt.a, tt.b); equal != tt.equal {
+			t.Errorf("%v equal(%v, %v) = %v, want %v", tt.rule, tt.a, tt.b, equal, tt.equal)
+		}
+		if equal := tt.rule.equal(tt.b, tt.a); equal != tt.equal {
+			t.Errorf("%v equal(%v, %v) = %v, want %v", tt.rule, tt.b, tt.a, equal, tt.equal)
+		}
+	}
+}
+
+func TestNumericTokens(t *testing.T) {
+	tokens := numericTokens([]byte("x=1.5 é 2\n\n  -3e2\tend\n"))
+	want := []numericToken{
+		{text: "x=", line: 1, col: 1},
+		{text: "1.5", number: true, value: 1.5, line: 1, col: 3},
+		{text: "é", line: 1, col: 7},
+		{text: "2", number: true, value: 2, line: 1, col: 9}, // Columns count characters, not bytes
+		{text: "-3e2", number: true, value: -300, line: 3, col: 3},
+		{text: "end", line: 3, col: 8},
+	}
+	if !reflect.DeepEqual(tokens, want) {
+		t.Errorf("numericTokens = %+v, want %+v", tokens, want)
+	}
+}
+
+func TestNumericFirstDifference(t *testing.T) {
+	tests := []struct {
+		rule             numericRule
+		expected, actual string
+		msg              string
+	}{
+		{numericRule{Abs: 0.1}, "a 1.0\n", "a  1.05", ""}, // Whitespace only separates tokens
+		{numericRule{Abs: 0.1}, "a 1.0", "a 1.5", "Line 1, column 3: 1.5 is out of tolerance (expected 1.0, difference 0.5, tolerance abs 0.1, rel 0)"},
+		{numericRule{}, "1 2", "1", `Expected "2" (line 1, column 3 of the expected file), but the file ended`},
+		{numericRule{}, "1", "1\n2", `Line 2, column 1: expected the end of the file, got "2"`},
+		{numericRule{}, "a 1", "b 1", `Line 1, column 1: expected "a", got "b"`},
+		{numericRule{Abs: 1}, "1", "x", `Line 1, column 1: expected "1", got "x"`},
+	}
+	for _, tt := range tests {
+		msg := tt.rule.firstDifference(numericTokens([]byte(tt.expected)), numericTokens([]byte(tt.actual)))
+		if msg != tt.msg {
+			t.Errorf("%v firstDifference(%q, %q) = %q, want %q", tt.rule, tt.expected, tt.actual, msg, tt.msg)
+		}
+	}
+}
+
+func TestNumericMatch(t *testing.T) {
+	r := resultsWithFiles(t, map[string]string{"exp": "t = 1.00 s\n", "act": "t = 1.004 s\n", "bad": "t = 1.1 s\n"})
+	r.numericMatch(0, numericRule{Files: []string{"exp", "act"}, Abs: 0.01})
+	if !r.passed {
+		t.Errorf("numericMatch within tolerance failed: %s", failures(r))
+	}
+	r.numericMatch(0, numericRule{Files: []string{"exp", "bad"}, Abs: 0.01})
+	if r.passed || !strings.Contains(failures(r), "1.1 is out of tolerance") || len(r.mismatches) != 1 {
+		t.Errorf("numericMatch out of tolerance = %q", failures(r))
+	}
+
+	r = resultsWithFiles(t, map[string]string{"exp": "1", "act": "1"})
+	r.numericMatch(0, numericRule{Files: []string{"exp", "act"}, Rel: -1})
+	if !r.misconfigured {
+		t.Errorf("numericMatch with a negative tolerance wasn't a configuration failure: %q", failures(r))
+	}
+}
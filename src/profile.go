@@ -2,9 +2,11 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"reflect"
 	"strconv"
 	"strings"
 )
@@ -15,28 +17,214 @@ const (
 
 // Generated with http://mervine.net/json2struct because I'm lazy
 type testProfile struct {
-	After             []string        `json:"after"`
-	Before            []string        `json:"before"`
-	Command           *string         `json:"command"`
-	Noconcurrent      *bool           `json:noconcurrent`
-	Name              *string         `json:"name"`
-	Next              *testProfile    `json:"next"`
-	Pass              *passConditions `json:"pass"`
-	RequiredFiles     []string        `json:"requiredFiles"`
-	CreateRequired    *bool           `json:createRequired`
-	Stderr            *string         `json:"stderr"`
-	Stdin             []string        `json:"stdin"`
-	Stdout            *string         `json:"stdout"`
-	LimitOutput       *int64          `json:limitOutput`
-	MaxTimePerCommand *int64          `json:maxTimePerCommand`
+	After             []string        `json:"after,omitempty"`
+	Before            []string        `json:"before,omitempty"`
+	Command           *string         `json:"command,omitempty"`
+	ExpectFail        *bool           `json:"expectFail,omitempty"`
+	Noconcurrent      *bool           `json:"noconcurrent,omitempty"`
+	Name              *string         `json:"name,omitempty"`
+	Next              *testProfile    `json:"next,omitempty"`
+	Pass              *passConditions `json:"pass,omitempty"`
+	RequiredFiles     []string        `json:"requiredFiles,omitempty"`
+	Skip              *bool           `json:"skip,omitempty"`
+	CreateRequired    *bool           `json:"createRequired,omitempty"`
+	Stderr            *string         `json:"stderr,omitempty"`
+	Stdin             []string        `json:"stdin,omitempty"`
+	Stdout            *string         `json:"stdout,omitempty"`
+	Tags              []string        `json:"tags,omitempty"`
+	Watch             []string        `json:"watch,omitempty"`
+	LimitOutput       *int64          `json:"limitOutput,omitempty"`
+	MaxTimePerCommand *int64          `json:"maxTimePerCommand,omitempty"`
 }
 
 type passConditions struct {
-	ZeroExit                 *bool      `json:"zeroExit"`
-	Match                    [][]string `json:"match"`
-	Rmatch                   [][]string `json:"rmatch"`
-	LimitReached             *bool      `json:limitReached`
-	MaxTimePerCommandReached *bool      `json:maxTimePerCommandReached`
+	ZeroExit                 *bool         `json:"zeroExit,omitempty"`
+	ExitCode                 exitCodes     `json:"exitCode,omitempty"`
+	Signal                   *string       `json:"signal,omitempty"`
+	Match                    []fileRule    `json:"match,omitempty"`
+	Rmatch                   []fileRule    `json:"rmatch,omitempty"`
+	LimitReached             *bool         `json:"limitReached,omitempty"`
+	MaxTimePerCommandReached *bool         `json:"maxTimePerCommandReached,omitempty"`
+	Check                    []string      `json:"check,omitempty"` // Commands which have to exit with a zero status
+	JSONMatch                []jsonRule    `json:"jsonMatch,omitempty"`
+	NumericMatch             []numericRule `json:"numericMatch,omitempty"`
+	SetMatch                 []setRule     `json:"setMatch,omitempty"`
+
+	// Groups of conditions. Conditions in a group aren't inherited from the
+	// default profile.
+	AnyOf []*passConditions `json:"anyOf,omitempty"` // At least one has to pass
+	AllOf []*passConditions `json:"allOf,omitempty"` // Every one has to pass
+	Not   *passConditions   `json:"not,omitempty"`   // Has to fail
+}
+
+// Modes for rmatch rules
+const (
+	rmatchSearch = "search" // The regular expression matches somewhere in the file (the default)
+	rmatchLines  = "lines"  // Each line of the file fully matches the same line of the regular expression file
+	rmatchFull   = "full"   // The regular expression matches the whole file
+)
+
+// Check that a rule's rmatch mode is one of the known ones
+func (f fileRule) checkMode() error {
+	switch f.Mode {
+	case "", rmatchSearch, rmatchLines, rmatchFull:
+		return nil
+	}
+	return errors.New("unknown mode: " + f.Mode + " (expected " + rmatchSearch + ", " + rmatchLines + " or " + rmatchFull + ")")
+}
+
+// A match or rmatch rule: the files to compare, and options for the rule
+type fileRule struct {
+	Files     []string        `json:"files"`
+	Mode      string          `json:"mode,omitempty"`      // rmatch only
+	Normalize []normalizeStep `json:"normalize,omitempty"` // Applied to the files before they're compared (but not to regular expression files)
+}
+
+func (f *fileRule) UnmarshalJSON(data []byte) error {
+	type plainRule fileRule
+	return unmarshalRule(data, "match", &f.Files, (*plainRule)(f))
+}
+
+func (f fileRule) MarshalJSON() ([]byte, error) {
+	type plainRule fileRule
+	return marshalRule(f.Files, f.Mode != "" || f.Normalize != nil, plainRule(f))
+}
+
+func (f fileRule) String() (s string) {
+	s = strings.Join(f.Files, ", ")
+	if f.Mode != "" {
+		s += " (mode: " + f.Mode + ")"
+	}
+	if f.Normalize != nil {
+		steps := make([]string, 0, len(f.Normalize))
+		for _, v := range f.Normalize {
+			steps = append(steps, v.String())
+		}
+		s += " (normalize: " + strings.Join(steps, ", ") + ")"
+	}
+	return
+}
+
+// Lists which are set but empty override the default profile, so they're
+// written out even though omitempty would leave them out
+func (p testProfile) MarshalJSON() ([]byte, error) {
+	type plainProfile testProfile
+	return marshalKeepingEmptyLists(p, plainProfile(p))
+}
+
+func (c passConditions) MarshalJSON() ([]byte, error) {
+	type plainConditions passConditions
+	return marshalKeepingEmptyLists(c, plainConditions(c))
+}
+
+// Marshal plain (v without its MarshalJSON method), adding the empty but
+// non-nil lists of v. The fields are kept in the order of the struct.
+func marshalKeepingEmptyLists(v, plain interface{}) ([]byte, error) {
+	b, err := json.Marshal(plain)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	err = json.Unmarshal(b, &fields)
+	if err != nil {
+		return nil, err
+	}
+	value := reflect.ValueOf(v)
+	b = []byte{'{'}
+	for k := 0; k < value.NumField(); k++ {
+		name := strings.Split(value.Type().Field(k).Tag.Get("json"), ",")[0]
+		raw, ok := fields[name]
+		if !ok {
+			field := value.Field(k)
+			if field.Kind() != reflect.Slice || field.IsNil() || field.Len() > 0 {
+				continue
+			}
+			raw = json.RawMessage("[]")
+		}
+		if len(b) > 1 {
+			b = append(b, ',')
+		}
+		b = append(b, []byte(strconv.Quote(name)+":")...)
+		b = append(b, raw...)
+	}
+	return append(b, '}'), nil
+}
+
+// Check that every profile in the next chain has valid groups of
+// conditions
+func (p *testProfile) checkGroups() error {
+	for ; p != nil; p = p.Next {
+		if err := p.Pass.checkGroups(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Check that no branch of a group is null, which would leave nothing to
+// check
+func (c *passConditions) checkGroups() error {
+	if c == nil {
+		return nil
+	}
+	for k, v := range c.AnyOf {
+		if v == nil {
+			return errors.New("anyOf branch " + strconv.Itoa(k+1) + " is null")
+		}
+		if err := v.checkGroups(); err != nil {
+			return err
+		}
+	}
+	for k, v := range c.AllOf {
+		if v == nil {
+			return errors.New("allOf branch " + strconv.Itoa(k+1) + " is null")
+		}
+		if err := v.checkGroups(); err != nil {
+			return err
+		}
+	}
+	return c.Not.checkGroups()
+}
+
+// Record the kinds of condition which are set, including in groups
+func (c *passConditions) setKinds(kinds map[string]bool) {
+	if c == nil {
+		return
+	}
+	if c.ZeroExit != nil || c.ExitCode != nil || c.Signal != nil {
+		kinds["exit"] = true
+	}
+	if c.Match != nil {
+		kinds["match"] = true
+	}
+	if c.Rmatch != nil {
+		kinds["rmatch"] = true
+	}
+	if c.Check != nil {
+		kinds["check"] = true
+	}
+	if c.JSONMatch != nil {
+		kinds["jsonMatch"] = true
+	}
+	if c.NumericMatch != nil {
+		kinds["numericMatch"] = true
+	}
+	if c.SetMatch != nil {
+		kinds["setMatch"] = true
+	}
+	if c.LimitReached != nil {
+		kinds["limitReached"] = true
+	}
+	if c.MaxTimePerCommandReached != nil {
+		kinds["maxTimePerCommandReached"] = true
+	}
+	for _, v := range c.AnyOf {
+		v.setKinds(kinds)
+	}
+	for _, v := range c.AllOf {
+		v.setKinds(kinds)
+	}
+	c.Not.setKinds(kinds)
 }
 
 func newProfile(testdir string, r *testResults) (p *testProfile) {
@@ -49,7 +237,9 @@ func newProfile(testdir string, r *testResults) (p *testProfile) {
 		err := json.Unmarshal(profileBytes, p)
 		if err != nil {
 			log.Println("Unable to unmarshal config JSON: ", err)
-			r.fail("Unable to unmarshal config JSON")
+			r.configFail("Unable to unmarshal config JSON")
+		} else if err := p.checkGroups(); err != nil {
+			r.configFail("Invalid pass conditions: " + err.Error())
 		}
 	}
 	if false {
@@ -103,6 +293,12 @@ func (p *testProfile) copyUnsetFrom(defaultProfile *testProfile) {
 	if p.Stdin == nil && defaultProfile.Stdin != nil {
 		p.Stdin = defaultProfile.Stdin
 	}
+	if p.Tags == nil && defaultProfile.Tags != nil {
+		p.Tags = defaultProfile.Tags
+	}
+	if p.Watch == nil && defaultProfile.Watch != nil {
+		p.Watch = defaultProfile.Watch
+	}
 
 	if p.Command == nil && defaultProfile.Command != nil {
 		newCommand := *defaultProfile.Command
@@ -124,6 +320,14 @@ func (p *testProfile) copyUnsetFrom(defaultProfile *testProfile) {
 		newPass := *defaultProfile.Pass
 		p.Pass = &newPass
 	}
+	if p.ExpectFail == nil && defaultProfile.ExpectFail != nil {
+		newExpectFail := *defaultProfile.ExpectFail
+		p.ExpectFail = &newExpectFail
+	}
+	if p.Skip == nil && defaultProfile.Skip != nil {
+		newSkip := *defaultProfile.Skip
+		p.Skip = &newSkip
+	}
 	if p.CreateRequired == nil && defaultProfile.CreateRequired != nil {
 		newCreateRequired := *defaultProfile.CreateRequired
 		p.CreateRequired = &newCreateRequired
@@ -155,24 +359,69 @@ func (p *testProfile) copyUnsetFrom(defaultProfile *testProfile) {
 	}
 
 	if p.Pass != nil && defaultProfile.Pass != nil {
-		if p.Pass.ZeroExit == nil && defaultProfile.Pass.ZeroExit != nil {
-			newZeroExit := *defaultProfile.Pass.ZeroExit
-			p.Pass.ZeroExit = &newZeroExit
+		// Kinds of condition the profile uses in groups aren't inherited,
+		// since the groups are there to replace them (e.g., matching either
+		// of two files, instead of the default file)
+		grouped := make(map[string]bool)
+		for _, v := range p.Pass.AnyOf {
+			v.setKinds(grouped)
+		}
+		for _, v := range p.Pass.AllOf {
+			v.setKinds(grouped)
+		}
+		p.Pass.Not.setKinds(grouped)
+
+		// The exit status conditions are inherited together, so a profile
+		// expecting a particular exit code doesn't also need a zero one
+		if p.Pass.ZeroExit == nil && p.Pass.ExitCode == nil && p.Pass.Signal == nil && !grouped["exit"] {
+			if defaultProfile.Pass.ZeroExit != nil {
+				newZeroExit := *defaultProfile.Pass.ZeroExit
+				p.Pass.ZeroExit = &newZeroExit
+			}
+			p.Pass.ExitCode = defaultProfile.Pass.ExitCode
+			if defaultProfile.Pass.Signal != nil {
+				newSignal := *defaultProfile.Pass.Signal
+				p.Pass.Signal = &newSignal
+			}
 		}
-		if p.Pass.LimitReached == nil && defaultProfile.Pass.LimitReached != nil {
+		if p.Pass.LimitReached == nil && defaultProfile.Pass.LimitReached != nil && !grouped["limitReached"] {
 			newLimitReached := *defaultProfile.Pass.LimitReached
 			p.Pass.LimitReached = &newLimitReached
 		}
-		if p.Pass.MaxTimePerCommandReached == nil && defaultProfile.Pass.MaxTimePerCommandReached != nil {
+		if p.Pass.MaxTimePerCommandReached == nil && defaultProfile.Pass.MaxTimePerCommandReached != nil && !grouped["maxTimePerCommandReached"] {
 			newMaxTimePerCommandReached := *defaultProfile.Pass.MaxTimePerCommandReached
 			p.Pass.MaxTimePerCommandReached = &newMaxTimePerCommandReached
 		}
 
-		if p.Pass.Match == nil {
-			copy(p.Pass.Match, defaultProfile.Pass.Match)
+		// An empty (but not null) list of rules overrides the default rules
+		if p.Pass.Match == nil && defaultProfile.Pass.Match != nil && !grouped["match"] {
+			p.Pass.Match = defaultProfile.Pass.Match
 		}
-		if p.Pass.Rmatch == nil {
-			copy(p.Pass.Rmatch, defaultProfile.Pass.Rmatch)
+		if p.Pass.Rmatch == nil && defaultProfile.Pass.Rmatch != nil && !grouped["rmatch"] {
+			p.Pass.Rmatch = defaultProfile.Pass.Rmatch
+		}
+		if p.Pass.Check == nil && defaultProfile.Pass.Check != nil && !grouped["check"] {
+			p.Pass.Check = defaultProfile.Pass.Check
+		}
+		if p.Pass.JSONMatch == nil && defaultProfile.Pass.JSONMatch != nil && !grouped["jsonMatch"] {
+			p.Pass.JSONMatch = defaultProfile.Pass.JSONMatch
+		}
+		if p.Pass.NumericMatch == nil && defaultProfile.Pass.NumericMatch != nil && !grouped["numericMatch"] {
+			p.Pass.NumericMatch = defaultProfile.Pass.NumericMatch
+		}
+		if p.Pass.SetMatch == nil && defaultProfile.Pass.SetMatch != nil && !grouped["setMatch"] {
+			p.Pass.SetMatch = defaultProfile.Pass.SetMatch
+		}
+
+		// Groups are inherited whole
+		if p.Pass.AnyOf == nil && defaultProfile.Pass.AnyOf != nil {
+			p.Pass.AnyOf = defaultProfile.Pass.AnyOf
+		}
+		if p.Pass.AllOf == nil && defaultProfile.Pass.AllOf != nil {
+			p.Pass.AllOf = defaultProfile.Pass.AllOf
+		}
+		if p.Pass.Not == nil && defaultProfile.Pass.Not != nil {
+			p.Pass.Not = defaultProfile.Pass.Not
 		}
 	}
 
@@ -203,14 +452,20 @@ func (p *testProfile) String() (s string) {
 		if p.Pass.ZeroExit != nil {
 			s += "\nPass.ZeroExit: " + strconv.FormatBool(*p.Pass.ZeroExit)
 		}
+		if p.Pass.ExitCode != nil {
+			s += "\nPass.ExitCode: " + p.Pass.ExitCode.String()
+		}
+		if p.Pass.Signal != nil {
+			s += "\nPass.Signal: " + *p.Pass.Signal
+		}
 		if p.Pass.Match != nil {
 			for _, v := range p.Pass.Match {
-				s += "\nPass.Match: " + strings.Join(v, ", ")
+				s += "\nPass.Match: " + v.String()
 			}
 		}
 		if p.Pass.Rmatch != nil {
 			for _, v := range p.Pass.Rmatch {
-				s += "\nPass.Rmatch: " + strings.Join(v, ", ")
+				s += "\nPass.Rmatch: " + v.String()
 			}
 		}
 		if p.Pass.LimitReached != nil {
@@ -219,10 +474,40 @@ func (p *testProfile) String() (s string) {
 		if p.Pass.MaxTimePerCommandReached != nil {
 			s += "\nPass.MaxTimePerCommandReached: " + strconv.FormatBool(*p.Pass.MaxTimePerCommandReached)
 		}
+		for _, v := range p.Pass.Check {
+			s += "\nPass.Check: " + v
+		}
+		for _, v := range p.Pass.JSONMatch {
+			s += "\nPass.JSONMatch: " + v.String()
+		}
+		for _, v := range p.Pass.NumericMatch {
+			s += "\nPass.NumericMatch: " + v.String()
+		}
+		for _, v := range p.Pass.SetMatch {
+			s += "\nPass.SetMatch: " + v.String()
+		}
+		for k, v := range p.Pass.AnyOf {
+			groupBytes, _ := json.Marshal(v)
+			s += "\nPass.AnyOf[" + strconv.Itoa(k) + "]: " + string(groupBytes)
+		}
+		for k, v := range p.Pass.AllOf {
+			groupBytes, _ := json.Marshal(v)
+			s += "\nPass.AllOf[" + strconv.Itoa(k) + "]: " + string(groupBytes)
+		}
+		if p.Pass.Not != nil {
+			groupBytes, _ := json.Marshal(p.Pass.Not)
+			s += "\nPass.Not: " + string(groupBytes)
+		}
 	}
 	if p.RequiredFiles != nil {
 		s += "\nRequiredFiles: " + strings.Join(p.RequiredFiles, ", ")
 	}
+	if p.ExpectFail != nil { // *bool
+		s += "\nExpectFail: " + strconv.FormatBool(*p.ExpectFail)
+	}
+	if p.Skip != nil { // *bool
+		s += "\nSkip: " + strconv.FormatBool(*p.Skip)
+	}
 	if p.CreateRequired != nil { // *bool
 		s += "\nCreateRequired: " + strconv.FormatBool(*p.CreateRequired)
 	}
@@ -235,6 +520,12 @@ func (p *testProfile) String() (s string) {
 	if p.Stdout != nil { // *string
 		s += "\nStdout: " + *p.Stdout
 	}
+	if p.Tags != nil {
+		s += "\nTags: " + strings.Join(p.Tags, ", ")
+	}
+	if p.Watch != nil {
+		s += "\nWatch: " + strings.Join(p.Watch, ", ")
+	}
 	if p.LimitOutput != nil { // *int64
 		s += "\nLimitOutput: " + strconv.FormatInt(*p.LimitOutput, 10)
 	}
@@ -0,0 +1,16 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestProfileMarshalKeepsEmptyLists(t *testing.T) {
+	name, command := "a", "true"
+	p := testProfile{After: []string{}, Before: []string{"x"}, Command: &command, Name: &name, Pass: &passConditions{Match: []fileRule{}}, Stdin: []string{}}
+	b, err := json.Marshal(p)
+	want := `{"after":[],"before":["x"],"command":"true","name":"a","pass":{"match":[]},"stdin":[]}`
+	if err != nil || string(b) != want {
+		t.Errorf("Marshal = %s, %v, want %s", b, err, want)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	statusInterval       = 200 * time.Millisecond // How often the status line is redrawn
+	defaultTerminalWidth = 80
+)
+
+// Prints the results of each test as it finishes. When stdout is a
+// terminal, a status line with the running tests and the counts so far is
+// kept below the results.
+type textReporter struct {
+	mu               sync.Mutex
+	showWarnings     bool
+	showInfo         bool
+	live             bool // Show the status line (cleared when the run ends)
+	statusShown      bool
+	running          map[*test]time.Time // When each running test started
+	passed           int
+	failed           int
+	skipped          int
+	expectedFailures int
+	remaining        int
+}
+
+func newTextReporter(showWarnings, showInfo bool) *textReporter {
+	return &textReporter{
+		showWarnings: showWarnings,
+		showInfo:     showInfo,
+		live:         isTerminal(os.Stdout),
+		running:      make(map[*test]time.Time),
+	}
+}
+
+// The width of the terminal f is attached to
+func terminalWidth(f *os.File) int {
+	var size struct {
+		rows, cols, xpixels, ypixels uint16
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), syscall.TIOCGWINSZ, uintptr(unsafe.Pointer(&size)))
+	if errno != 0 || size.cols == 0 {
+		return defaultTerminalWidth
+	}
+	return int(size.cols)
+}
+
+func (r *textReporter) suiteStart(tests *list.List) {
+	r.remaining = tests.Len()
+	if r.live {
+		go r.refresh()
+	}
+}
+
+// Redraw the status line every so often, so the times of the running tests
+// keep moving
+func (r *textReporter) refresh() {
+	for {
+		time.Sleep(statusInterval)
+		r.mu.Lock()
+		if !r.live {
+			r.mu.Unlock()
+			return
+		}
+		r.drawStatus()
+		r.mu.Unlock()
+	}
+}
+
+func (r *textReporter) drawStatus() {
+	running := make([]string, 0, len(r.running))
+	for t, started := range r.running {
+		running = append(running, t.testName+" "+time.Since(started).Round(100*time.Millisecond).String())
+	}
+	sort.Strings(running)
+
+	line := strconv.Itoa(r.passed) + " passed, " + strconv.Itoa(r.failed) + " failed, "
+	if r.skipped > 0 {
+		line += strconv.Itoa(r.skipped) + " skipped, "
+	}
+	if r.expectedFailures > 0 {
+		line += strconv.Itoa(r.expectedFailures) + " expected failures, "
+	}
+	line += strconv.Itoa(r.remaining) + " remaining"
+	if len(running) > 0 {
+		line += " | running: " + strings.Join(running, ", ")
+	}
+	width := terminalWidth(os.Stdout) - 1
+	if runes := []rune(line); len(runes) > width {
+		line = string(runes[:width-3]) + "..."
+	}
+	fmt.Print("\r\033[K" + line)
+	r.statusShown = true
+}
+
+func (r *textReporter) clearStatus() {
+	if r.statusShown {
+		fmt.Print("\r\033[K")
+		r.statusShown = false
+	}
+}
+
+func (r *textReporter) testStart(t *test) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.running[t] = time.Now()
+	if r.live {
+		r.drawStatus()
+	}
+}
+
+func (r *textReporter) stepEnd(t *test, step *stepResult) {}
+
+func (r *textReporter) testEnd(t *test) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.running, t)
+	r.remaining--
+	switch {
+	case t.results.skipped:
+		r.skipped++
+	case t.results.expectedFailure:
+		r.expectedFailures++
+	case t.results.passed:
+		r.passed++
+	default:
+		r.failed++
+	}
+	r.clearStatus()
+	t.results.print(r.showWarnings, r.showInfo)
+	if r.live {
+		r.drawStatus()
+	}
+}
+
+func (r *textReporter) suiteEnd(tests *list.List, summary *runSummary) error {
+	r.mu.Lock()
+	r.live = false
+	r.clearStatus()
+	r.mu.Unlock()
+	summary.print()
+	return nil
+}
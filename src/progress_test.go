@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestTextReporterCounts(t *testing.T) {
+	r := newTextReporter(false, false)
+	r.live = false
+	r.remaining = 4
+	for _, name := range []string{"passed", "skipped", "xfail", "failed"} {
+		results := newResults()
+		results.events = nil
+		results.testName = &name
+		switch name {
+		case "skipped":
+			results.skipped = true
+		case "xfail":
+			results.passed = false
+			results.expectedFailure = true
+		case "failed":
+			results.passed = false
+		}
+		r.testEnd(&test{testName: name, results: results})
+	}
+	if r.passed != 1 || r.failed != 1 || r.skipped != 1 || r.expectedFailures != 1 || r.remaining != 0 {
+		t.Errorf("counts = %d passed, %d failed, %d skipped, %d expected failures, %d remaining", r.passed, r.failed, r.skipped, r.expectedFailures, r.remaining)
+	}
+}
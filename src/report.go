@@ -0,0 +1,54 @@
+package main
+
+import (
+	"container/list"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Writes a report file for a finished run
+type reportWriter func(path string, tests *list.List, started time.Time, elapsed time.Duration) error
+
+var reportWriters = map[string]reportWriter{
+	"html":  writeHTMLReport,
+	"junit": writeJUnitReport,
+}
+
+// A repeatable -report kind=path flag
+type reportFlag []reportSpec
+
+type reportSpec struct {
+	kind string
+	path string
+}
+
+func reportKinds() string {
+	kinds := make([]string, 0, len(reportWriters))
+	for k := range reportWriters {
+		kinds = append(kinds, k)
+	}
+	sort.Strings(kinds)
+	return strings.Join(kinds, ", ")
+}
+
+func (r *reportFlag) String() string {
+	specs := make([]string, 0, len(*r))
+	for _, v := range *r {
+		specs = append(specs, v.kind+"="+v.path)
+	}
+	return strings.Join(specs, " ")
+}
+
+func (r *reportFlag) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return errors.New("expected kind=path")
+	}
+	if _, ok := reportWriters[parts[0]]; !ok {
+		return errors.New("unknown report kind " + parts[0] + " (expected one of: " + reportKinds() + ")")
+	}
+	*r = append(*r, reportSpec{parts[0], parts[1]})
+	return nil
+}
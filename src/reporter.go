@@ -0,0 +1,191 @@
+package main
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// Receives the lifecycle of a run. The test and step methods may be called
+// from several goroutines at once.
+type reporter interface {
+	suiteStart(tests *list.List)
+	testStart(t *test)
+	stepEnd(t *test, step *stepResult)
+	testEnd(t *test)
+	suiteEnd(tests *list.List, summary *runSummary) error
+}
+
+// An external reporter from the configuration file. The command is run
+// with sh -c, and is given the run's events as newline-delimited JSON on
+// its stdin (the same stream as 'yoke run -json').
+type externalReporterConfig struct {
+	Name    string `json:"name"`
+	Command string `json:"command"`
+}
+
+// Passes everything on to several reporters
+type reporterList []reporter
+
+func (l reporterList) suiteStart(tests *list.List) {
+	for _, v := range l {
+		v.suiteStart(tests)
+	}
+}
+
+func (l reporterList) testStart(t *test) {
+	for _, v := range l {
+		v.testStart(t)
+	}
+}
+
+func (l reporterList) stepEnd(t *test, step *stepResult) {
+	for _, v := range l {
+		v.stepEnd(t, step)
+	}
+}
+
+func (l reporterList) testEnd(t *test) {
+	for _, v := range l {
+		v.testEnd(t)
+	}
+}
+
+// Every reporter is finished, even if some fail. Failures are printed, and
+// the last is returned.
+func (l reporterList) suiteEnd(tests *list.List, summary *runSummary) (err error) {
+	for _, v := range l {
+		reporterErr := v.suiteEnd(tests, summary)
+		if reporterErr != nil {
+			fmt.Fprintln(os.Stderr, reporterErr)
+			err = reporterErr
+		}
+	}
+	return
+}
+
+// The reporters which receive the events of tests as they happen
+func (l reporterList) eventListeners() (listeners eventListenerList) {
+	for _, v := range l {
+		if listener, ok := v.(eventListener); ok {
+			listeners = append(listeners, listener)
+		}
+	}
+	return
+}
+
+// Writes the events of the run as newline-delimited JSON. Events about
+// tests come through event; the reporter methods add the start and end of
+// the run and of each step.
+type jsonReporter struct {
+	*jsonEventWriter
+}
+
+func newJSONReporter(w io.Writer) *jsonReporter {
+	return &jsonReporter{newJSONEventWriter(w)}
+}
+
+func (r *jsonReporter) suiteStart(tests *list.List) {
+	names := make([]string, 0, tests.Len())
+	for e := tests.Front(); e != nil; e = e.Next() {
+		names = append(names, e.Value.(*test).testName)
+	}
+	r.event(&testEvent{Time: time.Now(), Action: eventSuiteStart, Tests: names})
+}
+
+func (r *jsonReporter) testStart(t *test) {}
+
+func (r *jsonReporter) stepEnd(t *test, step *stepResult) {
+	r.event(&testEvent{
+		Time:       time.Now(),
+		Action:     eventStepEnd,
+		Test:       t.testName,
+		Step:       len(t.results.steps),
+		StepName:   step.name,
+		ExitStatus: step.exitStatus,
+		Elapsed:    seconds(step.duration),
+		Failures:   step.failures,
+	})
+}
+
+func (r *jsonReporter) testEnd(t *test) {}
+
+func (r *jsonReporter) suiteEnd(tests *list.List, summary *runSummary) error {
+	r.event(&testEvent{
+		Time:    time.Now(),
+		Action:  eventSuiteEnd,
+		Elapsed: seconds(summary.elapsed),
+		Message: summary.totals(),
+		Summary: &eventSummary{
+			Passed:           summary.passed,
+			Failed:           summary.failed,
+			Skipped:          summary.skipped,
+			ExpectedFailures: summary.expectedFailures,
+			FailedTests:      summary.failedTests,
+		},
+	})
+	return nil
+}
+
+// Writes a report file (see report.go) at the end of the run
+type fileReporter struct {
+	spec    reportSpec
+	started time.Time
+}
+
+func (r *fileReporter) suiteStart(tests *list.List) {
+	r.started = time.Now()
+}
+
+func (r *fileReporter) testStart(t *test)                 {}
+func (r *fileReporter) stepEnd(t *test, step *stepResult) {}
+func (r *fileReporter) testEnd(t *test)                   {}
+
+func (r *fileReporter) suiteEnd(tests *list.List, summary *runSummary) error {
+	err := reportWriters[r.spec.kind](r.spec.path, tests, r.started, summary.elapsed)
+	if err != nil {
+		return errors.New("Unable to write " + r.spec.kind + " report " + r.spec.path + ": " + err.Error())
+	}
+	return nil
+}
+
+// Runs an external reporter, feeding it the JSON event stream. Its output
+// goes to stderr, so it can't mix with yoke's own.
+type externalReporter struct {
+	*jsonReporter
+	name  string
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+}
+
+// Start an external reporter, so it's ready for the events of the tests as
+// they are loaded
+func newExternalReporter(c externalReporterConfig) (r *externalReporter, err error) {
+	r = &externalReporter{name: c.Name}
+	r.cmd = exec.Command("sh", "-c", c.Command)
+	r.cmd.Stdout = os.Stderr
+	r.cmd.Stderr = os.Stderr
+	r.stdin, err = r.cmd.StdinPipe()
+	if err == nil {
+		err = r.cmd.Start()
+	}
+	if err != nil {
+		return nil, errors.New("Unable to start reporter " + c.Name + ": " + err.Error())
+	}
+	r.jsonReporter = newJSONReporter(r.stdin)
+	return r, nil
+}
+
+func (r *externalReporter) suiteEnd(tests *list.List, summary *runSummary) error {
+	r.jsonReporter.suiteEnd(tests, summary)
+	r.stdin.Close()
+	err := r.cmd.Wait()
+	if err != nil {
+		return errors.New("Reporter " + r.name + " failed: " + err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+const (
+	sourceTest    = "test"
+	sourceDefault = "default"
+	sourceBuiltIn = "built-in"
+)
+
+// The default profile as written in the configuration file, before
+// fixNullReferences fills in the built-in defaults
+var configuredDefaultProfile testProfile
+
+// A resolved profile, along with where each of its settings came from
+type resolvedProfile struct {
+	Profile *testProfile      `json:"profile"`
+	Sources map[string]string `json:"sources"`
+}
+
+// Convert a profile to a generic JSON object, so its fields can be walked
+func profileMap(p *testProfile) (m map[string]interface{}) {
+	m = make(map[string]interface{})
+	profileBytes, err := json.Marshal(p)
+	if err == nil {
+		json.Unmarshal(profileBytes, &m)
+	}
+	return
+}
+
+// Record the source of every setting in merged. Settings are keyed by their
+// JSON path (e.g., "pass.zeroExit").
+func findSources(prefix string, merged, test, def map[string]interface{}, sources map[string]string) {
+	for k, v := range merged {
+		path := prefix + k
+		source := sourceBuiltIn
+		if _, ok := test[k]; ok {
+			source = sourceTest
+		} else if _, ok := def[k]; ok {
+			source = sourceDefault
+		}
+
+		obj, isObj := v.(map[string]interface{})
+		switch {
+		case k == "next" && isObj:
+			// Next steps are taken whole, rather than merged
+			findSources(path+".", obj, nil, nil, sources)
+			for subpath := range sources {
+				if strings.HasPrefix(subpath, path+".") {
+					sources[subpath] = source
+				}
+			}
+		case isObj:
+			testObj, _ := test[k].(map[string]interface{})
+			defObj, _ := def[k].(map[string]interface{})
+			findSources(path+".", obj, testObj, defObj, sources)
+		default:
+			sources[path] = source
+		}
+	}
+}
+
+func resolveProfile(testdir string) (r *resolvedProfile, err error) {
+	results := newResults()
+	results.testName = &testdir
+	p := newProfile(testdir, results)
+	if results.misconfigured {
+		return nil, errors.New("Unable to load profile for " + testdir)
+	}
+	test := profileMap(p)
+
+	p.copyUnsetFrom(&config.DefaultProfile)
+	r = new(resolvedProfile)
+	r.Profile = p
+	r.Sources = make(map[string]string)
+	findSources("", profileMap(p), test, profileMap(&configuredDefaultProfile), r.Sources)
+	return
+}
+
+func showProfile(args []string) error {
+	profileFlags := flag.NewFlagSet("profile", flag.ExitOnError)
+	resolved := profileFlags.Bool("resolved", false, "show the profile after the default profile is applied, with the source of each setting")
+	// Flags may come after the test name, as in 'yoke profile test -resolved'
+	names := make([]string, 0)
+	for profileFlags.Parse(args); profileFlags.NArg() > 0; profileFlags.Parse(args) {
+		names = append(names, profileFlags.Arg(0))
+		args = profileFlags.Args()[1:]
+	}
+
+	if len(names) != 1 {
+		return errors.New("Exactly one test must be given")
+	}
+	testdir := strings.TrimSuffix(names[0], "/")
+
+	var out interface{}
+	if *resolved {
+		r, err := resolveProfile(testdir)
+		if err != nil {
+			return err
+		}
+		out = r
+	} else {
+		profileBytes, err := ioutil.ReadFile(testdir + "/" + profileFileName)
+		if err != nil {
+			return errors.New("Unable to read profile: " + err.Error())
+		}
+		p := new(testProfile)
+		err = json.Unmarshal(profileBytes, p)
+		if err != nil {
+			return errors.New("Unable to unmarshal profile JSON: " + err.Error())
+		}
+		out = p
+	}
+
+	outBytes, err := json.MarshalIndent(out, "", "\t")
+	if err != nil {
+		return errors.New("Unable to marshal profile JSON: " + err.Error())
+	}
+	fmt.Println(string(outBytes))
+	return nil
+}
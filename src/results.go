@@ -1,30 +1,69 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
 	"container/list"
+	"errors"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"regexp"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type testResults struct {
 	testName          *string
 	passed            bool
+	skipped           bool
+	misconfigured     bool // The test's profile is unusable
+	duration          time.Duration
 	limitReached      bool
 	exceededTimeLimit []string
 	errorList         *list.List
 	infoList          *list.List
 	warningList       *list.List
 	cmd               *exec.Cmd
+	diffs             []string // Diffs for the files which didn't match
+	steps             []*stepResult
+	mismatches        []fileMismatch  // Rules which failed because files didn't match
+	expectedFailure   bool            // Failed, but the profile expects it to
+	stepErrorsStart   int             // Length of errorList when the current step started
+	events            eventListener   // Where events are sent as they happen (nil for nowhere)
+	branchCommands    []commandResult // Commands run while checking a branch's conditions
+}
+
+// A pair of files which didn't match
+type fileMismatch struct {
+	rule      string // match, rmatch, jsonMatch, numericMatch or setMatch
+	expected  string
+	actual    string
+	normalize []normalizeStep // How the files were normalized before comparing them
+}
+
+// The results of one step of a test (the test itself, or one of its next
+// steps)
+type stepResult struct {
+	name       string
+	commands   []commandResult // Every command run, in order
+	exitStatus string          // Exit status of the test command
+	duration   time.Duration
+	failures   []string
+	stdout     string // The start of the step's stdout and stderr files
+	stderr     string
+}
+
+// A command run by a step
+type commandResult struct {
+	command    string
+	exitStatus string // Empty if the command couldn't be started
+	duration   time.Duration
 }
 
 const (
-	matchReadBufferSize = 1024 // Buffer size to use when doing a byte comparison of files
+	excerptSize = 4096 // Number of bytes of each output file kept for reports
 )
 
 func newResults() (r *testResults) {
@@ -36,76 +75,86 @@ func newResults() (r *testResults) {
 	r.passed = true
 	r.limitReached = false
 	r.exceededTimeLimit = make([]string, 0)
+	r.events = defaultEventListener
 	return
 }
 
-func (r *testResults) match(index int, files []string) (ret bool) {
+func (r *testResults) match(index int, rule fileRule) (ret bool) {
+	files := rule.Files
 	if len(files) < 2 {
-		r.info("Not enough filenames provided for match rule (" + string(index) + ")")
+		r.info("Not enough filenames provided for match rule (" + strconv.Itoa(index) + ")")
 		return true
 	}
 
 	ret = true
-	// Build a slice of *os.File objects
-	fs := list.New()
+	names := make([]string, 0, len(files))
 	for _, v := range files {
-		f, err := os.Open(*r.testName + "/" + v)
-		defer f.Close()
+		filename := *r.testName + "/" + v
+		_, err := os.Stat(filename)
 		if err != nil {
 			r.fail("Unable to open file for comparison: " + v)
 			ret = false
 			continue
 		}
-		fs.PushBack(f)
-	}
-	for e := fs.Front(); e != nil; e = e.Next() {
-		if e.Next() != nil {
-			// Set up limited readers for the files, so we don't read too much
-			f1 := e.Value.(*os.File)
-			f1lr := io.LimitReader(f1, matchReadBufferSize)
-			f2 := e.Next().Value.(*os.File)
-			f2lr := io.LimitReader(f2, matchReadBufferSize)
-			// fmt.Printf("f1: %s, f2: %s\n", f1.Name(), f2.Name())
-			var f1lrBuf, f2lrBuf []byte // Buffers
-			f1lrBuf = make([]byte, matchReadBufferSize)
-			f2lrBuf = make([]byte, matchReadBufferSize)
-			for {
-				f1bytesRead, _ := f1lr.Read(f1lrBuf)
-				f2bytesRead, _ := f2lr.Read(f2lrBuf)
-				// fmt.Printf("f1buf: %s\nf2buf: %s\n", string(f1lrBuf), string(f2lrBuf))
-				if f1bytesRead != f2bytesRead || !bytes.Equal(f1lrBuf, f2lrBuf) {
-					ret = false
-					r.fail("Files don't match: " + f1.Name() + ", " + f2.Name())
-					break
-				}
-				if f1bytesRead == 0 && f2bytesRead == 0 {
-					r.info("Files match: " + f1.Name() + ", " + f2.Name())
-					// Files match, so we can move onto the next file
-					break
+		names = append(names, filename)
+	}
+	for k := 0; k+1 < len(names); k++ {
+		var equal bool
+		var a, b []byte
+		var err error
+		diff := ""
+		if rule.Normalize == nil {
+			// Files are only read in full if they're diffed
+			equal, err = filesEqual(names[k], names[k+1])
+			if err == nil && !equal {
+				if diffErr := checkDiffable(names[k], names[k+1]); diffErr != nil {
+					diff = diffErr.Error()
+				} else {
+					a, b, err = readNormalized(names[k], names[k+1], nil)
 				}
 			}
+		} else {
+			a, b, err = readNormalized(names[k], names[k+1], rule.Normalize)
+			equal = err == nil && bytes.Equal(a, b)
+		}
+		if err != nil {
+			r.configFail("Unable to compare " + names[k] + ", " + names[k+1] + ": " + err.Error())
+			return false
+		}
+		if equal {
+			r.info("Files match: " + names[k] + ", " + names[k+1])
+			continue
+		}
+		ret = false
+		if diff == "" {
+			diff = unifiedDiff(names[k], names[k+1], a, b)
+		}
+		r.diffs = append(r.diffs, diff)
+		r.mismatches = append(r.mismatches, fileMismatch{"match", names[k], names[k+1], rule.Normalize})
+		if rule.Normalize != nil {
+			r.fail("Files don't match (after normalizing): " + names[k] + ", " + names[k+1] + "\n" + diff)
+		} else {
+			r.fail("Files don't match: " + names[k] + ", " + names[k+1] + "\n" + diff)
 		}
 	}
 	// If we made it down to here, all the files matched (or weren't accessible)
 	return
 }
 
-func (r *testResults) rmatch(index int, files []string) {
+func (r *testResults) rmatch(index int, rule fileRule) {
+	files := rule.Files
 	if len(files) < 2 {
-		r.warn("Not enough filenames provided for match rule (" + string(index) + ")")
+		r.warn("Not enough filenames provided for match rule (" + strconv.Itoa(index) + ")")
 		return
 	}
-
-	// Open the first file (regexp)
-	reFile, err := os.Open(*r.testName + "/" + files[0])
-	defer reFile.Close()
-	if err != nil {
-		r.fail("Unable to open regular expression file: " + files[0])
+	if err := rule.checkMode(); err != nil {
+		r.configFail("Invalid rmatch rule (" + strconv.Itoa(index) + "): " + err.Error())
 		return
 	}
 
 	// Read regexp file
-	reBytes, err := ioutil.ReadAll(reFile)
+	reName := *r.testName + "/" + files[0]
+	reBytes, err := ioutil.ReadFile(reName)
 	if err != nil {
 		r.fail("Unable to read regular expression file: " + files[0])
 		return
@@ -115,51 +164,257 @@ func (r *testResults) rmatch(index int, files []string) {
 	}
 
 	// Compile regexp file
-	re, err := regexp.Compile(string(reBytes))
+	res, err := compileRmatch(string(reBytes), rule.Mode)
 	if err != nil {
-		r.fail("Unable to compile regular expression file: " + files[0])
+		r.fail("Unable to compile regular expression file: " + files[0] + ": " + err.Error())
 		return
 	}
 
-	// Build a list of *os.File objects
-	fs := list.New()
+	// Compare files to the regexp
 	for _, v := range files[1:] { // Skip the first file
-		f, err := os.Open(*r.testName + "/" + v)
-		defer f.Close()
+		filename := *r.testName + "/" + v
+		actual, err := ioutil.ReadFile(filename)
 		if err != nil {
 			r.fail("Unable to open file for comparison: " + v)
 			continue
 		}
-		fs.PushBack(f)
+		actual, err = normalize(actual, rule.Normalize)
+		if err != nil {
+			r.configFail("Unable to normalize " + v + ": " + err.Error())
+			return
+		}
+		var msg string
+		switch rule.Mode {
+		case rmatchLines:
+			msg = rmatchLinesMismatch(res, string(reBytes), actual)
+			if msg != "" {
+				msg = "Files don't match (using regular expression, line by line): " + reName + ", " + filename + "\n" + msg
+			}
+		case rmatchFull:
+			if !res[0].Match(actual) {
+				msg = "Files don't match (using regular expression, whole file): " + reName + ", " + filename + "\n" +
+					rmatchLineText(actual, rmatchFullMismatch(string(reBytes), actual))
+			}
+		default:
+			if !res[0].Match(actual) {
+				msg = "Files don't match (using regular expression): " + reName + ", " + filename
+			}
+		}
+		if msg != "" {
+			r.mismatches = append(r.mismatches, fileMismatch{"rmatch", reName, filename, rule.Normalize})
+			r.fail(msg)
+		}
 	}
+}
 
-	// Compare files to the regexp
-	for e := fs.Front(); e != nil; e = e.Next() {
-		// Set up limited readers for the files, so we don't read too much
-		f := e.Value.(*os.File)
-		if !re.MatchReader(bufio.NewReader(f)) {
-			r.fail("Files don't match (using regular expression): " + reFile.Name() + ", " + f.Name())
+// Split a file into lines for an rmatch rule. A newline at the end of the
+// file doesn't start another line.
+func rmatchSplit(text string) []string {
+	if text == "" {
+		return []string{}
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// Compile the regular expression file of an rmatch rule. In line mode, each
+// line is a separate regular expression, which must match a whole line.
+func compileRmatch(source, mode string) (res []*regexp.Regexp, err error) {
+	switch mode {
+	case rmatchLines:
+		for k, v := range rmatchSplit(source) {
+			re, err := regexp.Compile("^(?:" + v + ")$")
+			if err != nil {
+				return nil, errors.New("line " + strconv.Itoa(k+1) + ": " + err.Error())
+			}
+			res = append(res, re)
 		}
+		return res, nil
+	case rmatchFull:
+		re, err := regexp.Compile(`\A(?:` + source + `)\z`)
+		return []*regexp.Regexp{re}, err
+	case "", rmatchSearch:
+		re, err := regexp.Compile(source)
+		return []*regexp.Regexp{re}, err
 	}
-	// If we made it down to here, all the files matched (or weren't accessible)
+	return nil, fileRule{Mode: mode}.checkMode()
+}
+
+// Describe a line of a file, for a failure message. Line numbers start at 1.
+func rmatchLineText(text []byte, line int) string {
+	lines := rmatchSplit(string(text))
+	if line > len(lines) {
+		return "Line " + strconv.Itoa(line) + ": (end of file)"
+	}
+	return "Line " + strconv.Itoa(line) + ": " + lines[line-1]
+}
+
+// Find the first line of actual which doesn't fully match its line of the
+// regular expression file. Returns an empty string if every line matches.
+func rmatchLinesMismatch(res []*regexp.Regexp, source string, actual []byte) string {
+	patterns := rmatchSplit(source)
+	lines := rmatchSplit(string(actual))
+	for k := 0; k < len(res) || k < len(lines); k++ {
+		switch {
+		case k >= len(lines):
+			return rmatchLineText(actual, k+1) + "\nExpected: " + patterns[k]
+		case k >= len(res):
+			return rmatchLineText(actual, k+1) + "\nExpected: (end of file)"
+		case !res[k].MatchString(lines[k]):
+			return rmatchLineText(actual, k+1) + "\nExpected: " + patterns[k]
+		}
+	}
+	return ""
+}
+
+// Find the first line of actual where a whole-file regular expression stops
+// matching. The regular expression file is taken to line up with the file,
+// so the first line n where its first n lines (as a regular expression)
+// don't match the start of the file is the one reported. Prefixes which
+// can't be compiled on their own (e.g., ending inside a group) are skipped.
+// If every prefix matches, the line where the longest match from the start
+// of the file ends is reported instead.
+func rmatchFullMismatch(source string, actual []byte) int {
+	patterns := rmatchSplit(source)
+	for n := 1; n <= len(patterns); n++ {
+		prefix := strings.Join(patterns[:n], "\n")
+		if n < len(patterns) {
+			prefix += "\n"
+		}
+		re, err := regexp.Compile(`\A(?:` + prefix + `)`)
+		if err != nil {
+			continue
+		}
+		if !re.Match(actual) {
+			return n
+		}
+	}
+
+	re, err := regexp.Compile(`\A(?:` + source + `)`)
+	if err != nil {
+		return 1
+	}
+	re.Longest()
+	loc := re.FindIndex(actual)
+	if loc == nil {
+		return 1
+	}
+	return bytes.Count(actual[:loc[1]], []byte("\n")) + 1
+}
+
+func (r *testResults) startStep(p *testProfile) {
+	step := new(stepResult)
+	if p.Name != nil {
+		step.name = *p.Name
+	}
+	step.commands = make([]commandResult, 0)
+	r.steps = append(r.steps, step)
+	r.stepErrorsStart = r.errorList.Len()
+}
+
+func (r *testResults) currentStep() *stepResult {
+	return r.steps[len(r.steps)-1]
+}
+
+func (r *testResults) addCommand(c commandResult) {
+	if len(r.steps) == 0 { // A branch, whose commands are added to the step by mergeBranch
+		r.branchCommands = append(r.branchCommands, c)
+		return
+	}
+	step := r.currentStep()
+	step.commands = append(step.commands, c)
+}
+
+func (r *testResults) endStep(duration time.Duration, stdout, stderr string) {
+	step := r.currentStep()
+	step.duration = duration
+	step.stdout = stdout
+	step.stderr = stderr
+	i := 0
+	for e := r.errorList.Front(); e != nil; e = e.Next() {
+		if i >= r.stepErrorsStart {
+			step.failures = append(step.failures, e.Value.(string))
+		}
+		i++
+	}
+}
+
+// Results for checking a group of pass conditions on their own. They are
+// merged back with mergeBranch, once it's known whether their failures
+// count.
+func (r *testResults) branch() (b *testResults) {
+	b = newResults()
+	b.events = nil
+	b.testName = r.testName
+	b.cmd = r.cmd
+	b.limitReached = r.limitReached
+	b.exceededTimeLimit = append([]string(nil), r.exceededTimeLimit...)
 	return
+}
 
-	// r.fail("rmatch not yet implemented, so using regular match")
-	// t.match(index, files)
-	// return false
+// Merge the messages from a branch into r. The branch's failures (and the
+// diffs of its mismatched files) are only kept if failures is true, with
+// prefix added to each.
+func (r *testResults) mergeBranch(b *testResults, prefix string, failures bool) {
+	for _, v := range b.branchCommands {
+		r.addCommand(v)
+	}
+	for _, v := range listStrings(b.infoList) {
+		r.info(v)
+	}
+	for _, v := range listStrings(b.warningList) {
+		r.warn(v)
+	}
+	if b.misconfigured {
+		r.misconfigured = true
+	}
+	if !failures {
+		return
+	}
+	for _, v := range listStrings(b.errorList) {
+		r.fail(prefix + v)
+	}
+	r.diffs = append(r.diffs, b.diffs...)
+	r.mismatches = append(r.mismatches, b.mismatches...)
 }
 
 func (r *testResults) fail(msg string) {
 	r.errorList.PushBack(msg)
 	r.passed = false
+	r.emit(testEvent{Action: eventFailure, Message: msg})
+}
+
+// Fail because of a problem with the test's configuration, rather than the
+// program being tested
+func (r *testResults) configFail(msg string) {
+	r.fail(msg)
+	r.misconfigured = true
+}
+
+// Report whether the test failed, not counting skipped tests and tests which
+// are expected to fail
+func (r *testResults) failed() bool {
+	return !r.passed && !r.skipped && !r.expectedFailure
 }
 
 func (r *testResults) info(msg string) {
 	r.infoList.PushBack(msg)
+	r.emit(testEvent{Action: eventInfo, Message: msg})
 }
 
 func (r *testResults) warn(msg string) {
 	r.warningList.PushBack(msg)
+	r.emit(testEvent{Action: eventWarning, Message: msg})
+}
+
+// Colorize the diff at the end of a failure message, if it has one. The
+// rest of the message is left alone.
+func (r *testResults) colorizeDiffs(msg string) string {
+	for _, v := range r.diffs {
+		if strings.HasSuffix(msg, "\n"+v) {
+			return strings.TrimSuffix(msg, v) + colorizeDiff(v)
+		}
+	}
+	return msg
 }
 
 func (r *testResults) print(showWarnings, showInfo bool) {
@@ -173,13 +428,25 @@ func (r *testResults) print(showWarnings, showInfo bool) {
 		result = e.Value.(string)
 		fmt.Fprintln(os.Stderr, *r.testName+"(warning): "+result)
 	}
+	color := isTerminal(os.Stdout)
 	for e := r.errorList.Front(); e != nil; e = e.Next() {
 		var result string
 		result = e.Value.(string)
+		if color {
+			result = r.colorizeDiffs(result)
+		}
 		fmt.Fprintln(os.Stderr, *r.testName+"(failure): "+result)
 	}
-	if !r.passed {
-		fmt.Println(*r.testName + ": failed")
+	took := " (" + r.duration.Round(time.Millisecond).String() + ")"
+	switch {
+	case r.skipped:
+		fmt.Println(*r.testName + ": skipped")
+	case r.expectedFailure:
+		fmt.Println(*r.testName + ": failed, as expected" + took)
+	case !r.passed:
+		fmt.Println(*r.testName + ": failed" + took)
+	default:
+		fmt.Println(*r.testName + ": passed" + took)
 	}
 
 }
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Results for a test directory holding files, which is removed when the
+// test finishes
+func resultsWithFiles(t *testing.T, files map[string]string) *testResults {
+	dir, err := ioutil.TempDir("", "yoke")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := newResults()
+	r.events = nil
+	r.testName = &dir
+	return r
+}
+
+func failures(r *testResults) string {
+	return strings.Join(listStrings(r.errorList), "\n")
+}
+
+func TestCompileRmatch(t *testing.T) {
+	tests := []struct {
+		mode, source, text string
+		matches            bool
+	}{
+		{"", "b+", "abbc", true},
+		{rmatchSearch, "b+", "abbc", true},
+		{rmatchFull, "b+", "abbc", false},
+		{rmatchFull, "a.*c", "abbc", true},
+		{rmatchFull, "a.*c", "abbc\n", false}, // \z is the very end, so the newline counts
+		{rmatchLines, "a+\nb+\n", "aa\nbb", true},
+		{rmatchLines, "a+\nb+\n", "xaa\nbb", false}, // Each line is anchored
+	}
+	for _, tt := range tests {
+		res, err := compileRmatch(tt.source, tt.mode)
+		if err != nil {
+			t.Errorf("compileRmatch(%q, %q): %v", tt.source, tt.mode, err)
+			continue
+		}
+		var matches bool
+		if tt.mode == rmatchLines {
+			matches = rmatchLinesMismatch(res, tt.source, []byte(tt.text)) == ""
+		} else {
+			matches = res[0].MatchString(tt.text)
+		}
+		if matches != tt.matches {
+			t.Errorf("compileRmatch(%q, %q) matching %q = %v, want %v", tt.source, tt.mode, tt.text, matches, tt.matches)
+		}
+	}
+
+	if _, err := compileRmatch("a", "line"); err == nil {
+		t.Errorf("compileRmatch with an unknown mode didn't fail")
+	}
+	if _, err := compileRmatch("a\n(b\n", rmatchLines); err == nil || !strings.HasPrefix(err.Error(), "line 2:") {
+		t.Errorf("compileRmatch with a bad second line = %v, want an error for line 2", err)
+	}
+}
+
+func TestRmatchLinesMismatch(t *testing.T) {
+	tests := []struct {
+		source, text, msg string
+	}{
+		{"a\nb\n", "a\nb\n", ""},
+		{"a\nb\n", "a\nc\n", "Line 2: c\nExpected: b"},
+		{"a\nb\n", "a\n", "Line 2: (end of file)\nExpected: b"},
+		{"a\n", "a\nb\n", "Line 2: b\nExpected: (end of file)"},
+		{"", "", ""},
+	}
+	for _, tt := range tests {
+		res, err := compileRmatch(tt.source, rmatchLines)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg := rmatchLinesMismatch(res, tt.source, []byte(tt.text)); msg != tt.msg {
+			t.Errorf("rmatchLinesMismatch(%q, %q) = %q, want %q", tt.source, tt.text, msg, tt.msg)
+		}
+	}
+}
+
+func TestRmatchFullMismatch(t *testing.T) {
+	tests := []struct {
+		source, text string
+		line         int
+	}{
+		{"one\ntwo\nthree\n", "one\ntwo\nfour\n", 3},
+		{"one\ntwo\n", "one\nxyz\n", 2},
+		{"(one\ntwo)\n", "one\nxyz\n", 2}, // Lines which don't compile on their own
+	}
+	for _, tt := range tests {
+		if line := rmatchFullMismatch(tt.source, []byte(tt.text)); line != tt.line {
+			t.Errorf("rmatchFullMismatch(%q, %q) = %d, want %d", tt.source, tt.text, line, tt.line)
+		}
+	}
+}
+
+func TestRmatch(t *testing.T) {
+	r := resultsWithFiles(t, map[string]string{"re": "a[0-9]+\nb\n", "out": "a12\nb\n", "bad": "a12\nc\n"})
+	r.rmatch(0, fileRule{Files: []string{"re", "out"}, Mode: rmatchLines})
+	if !r.passed {
+		t.Errorf("rmatch of matching lines failed: %s", failures(r))
+	}
+
+	r.rmatch(0, fileRule{Files: []string{"re", "bad"}, Mode: rmatchLines})
+	if r.passed || !strings.Contains(failures(r), "Line 2: c\nExpected: b") {
+		t.Errorf("rmatch of a mismatched line = %q", failures(r))
+	}
+
+	r = resultsWithFiles(t, map[string]string{"re": "a", "out": "a"})
+	r.rmatch(0, fileRule{Files: []string{"re", "out"}, Mode: "line"})
+	if r.passed || !r.misconfigured || !strings.Contains(failures(r), "unknown mode: line") {
+		t.Errorf("rmatch with an unknown mode wasn't a configuration failure: %q", failures(r))
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"strconv"
+)
+
+// Rules comparing files are given either as a plain list of files or as an
+// object with the files and the rule's options.
+
+// Decode a rule. files is filled in for a plain list of files. Otherwise
+// the object is decoded into plain, a pointer to the rule converted to a
+// type without its JSON methods (e.g. type plainRule fileRule), since
+// decoding into the rule itself would call its UnmarshalJSON again. The same
+// goes for plain in marshalRule.
+func unmarshalRule(data []byte, kind string, files *[]string, plain interface{}) error {
+	if json.Unmarshal(data, files) == nil {
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(plain)
+	if err != nil {
+		return errors.New(kind + " rule must be a list of files or an object with files: " + err.Error())
+	}
+	return nil
+}
+
+// Encode a rule, as a plain list of files if it has no options
+func marshalRule(files []string, options bool, plain interface{}) ([]byte, error) {
+	if !options {
+		return json.Marshal(files)
+	}
+	return json.Marshal(plain)
+}
+
+// Read the files of a rule which compares each file with the next one,
+// then call compare for each pair. Files which can't be read fail the test
+// and are left out of the pairs.
+func (r *testResults) compareFiles(kind string, index int, files []string,
+	read func(filename string) (interface{}, error),
+	compare func(aName, bName string, a, b interface{})) {
+
+	if len(files) < 2 {
+		r.info("Not enough filenames provided for " + kind + " rule (" + strconv.Itoa(index) + ")")
+		return
+	}
+	names := make([]string, 0, len(files))
+	contents := make([]interface{}, 0, len(files))
+	for _, v := range files {
+		filename := *r.testName + "/" + v
+		content, err := read(filename)
+		if err != nil {
+			r.fail("Unable to read file for comparison: " + v + ": " + err.Error())
+			continue
+		}
+		names = append(names, filename)
+		contents = append(contents, content)
+	}
+	for k := 0; k+1 < len(contents); k++ {
+		compare(names[k], names[k+1], contents[k], contents[k+1])
+	}
+}
+
+// Read a file for compareFiles, as a []byte
+func readFileContent(filename string) (interface{}, error) {
+	return ioutil.ReadFile(filename)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Each kind of rule is given either as a list of files or as an object with
+// the files and options, and is written back the same way
+func TestRuleJSON(t *testing.T) {
+	cases := []struct {
+		kind    string
+		rules   interface{} // Pointer to a list of rules to decode into
+		in      string
+		want    interface{}
+		out     string
+		unknown string // A rule with a field this kind doesn't have
+	}{
+		{"match", new([]fileRule), `[["a", "b"], {"files": ["c", "d"], "mode": "lines"}]`,
+			[]fileRule{{Files: []string{"a", "b"}}, {Files: []string{"c", "d"}, Mode: rmatchLines}},
+			`[["a","b"],{"files":["c","d"],"mode":"lines"}]`,
+			`{"files": ["a", "b"], "unknown": 1}`},
+		{"jsonMatch", new([]jsonRule), `[["a", "b"], {"files": ["c", "d"], "unordered": true}]`,
+			[]jsonRule{{Files: []string{"a", "b"}}, {Files: []string{"c", "d"}, Unordered: true}},
+			`[["a","b"],{"files":["c","d"],"unordered":true}]`,
+			`{"files": ["a", "b"], "mode": "lines"}`},
+		{"numericMatch", new([]numericRule), `[["a", "b"], {"files": ["c", "d"], "abs": 0.5}]`,
+			[]numericRule{{Files: []string{"a", "b"}}, {Files: []string{"c", "d"}, Abs: 0.5}},
+			`[["a","b"],{"files":["c","d"],"abs":0.5}]`,
+			`{"files": ["a", "b"], "tolerance": 1}`},
+		{"setMatch", new([]setRule), `[["a", "b"], {"files": ["c", "d"], "ignoreDuplicates": true}]`,
+			[]setRule{{Files: []string{"a", "b"}}, {Files: []string{"c", "d"}, IgnoreDuplicates: true}},
+			`[["a","b"],{"files":["c","d"],"ignoreDuplicates":true}]`,
+			`{"files": ["a", "b"], "unordered": true}`},
+	}
+	for _, c := range cases {
+		err := json.Unmarshal([]byte(c.in), c.rules)
+		if err != nil {
+			t.Fatalf("%s: %v", c.kind, err)
+		}
+		rules := reflect.ValueOf(c.rules).Elem()
+		if !reflect.DeepEqual(rules.Interface(), c.want) {
+			t.Errorf("%s: Unmarshal = %+v, want %+v", c.kind, rules.Interface(), c.want)
+		}
+		b, err := json.Marshal(rules.Interface())
+		if err != nil || string(b) != c.out {
+			t.Errorf("%s: Marshal = %s, %v", c.kind, b, err)
+		}
+		err = json.Unmarshal([]byte(c.unknown), rules.Index(0).Addr().Interface())
+		if err == nil || !strings.HasPrefix(err.Error(), c.kind+" rule must be a list of files or an object with files") {
+			t.Errorf("%s: Unmarshal of a rule with an unknown field = %v", c.kind, err)
+		}
+	}
+}
+
+func TestCompareFiles(t *testing.T) {
+	r := resultsWithFiles(t, map[string]string{"a": "1", "b": "2", "c": "3"})
+	var pairs []string
+	compare := func(aName, bName string, a, b interface{}) {
+		pairs = append(pairs, a.(string)+b.(string))
+	}
+	read := func(filename string) (interface{}, error) {
+		b, err := ioutil.ReadFile(filename)
+		return string(b), err
+	}
+
+	r.compareFiles("test", 0, []string{"a", "b", "c"}, read, compare)
+	if !reflect.DeepEqual(pairs, []string{"12", "23"}) || !r.passed {
+		t.Errorf("compareFiles compared %q", pairs)
+	}
+
+	// Files which can't be read are left out of the pairs
+	pairs = nil
+	r.compareFiles("test", 1, []string{"a", "missing", "c"}, read, compare)
+	if !reflect.DeepEqual(pairs, []string{"13"}) || r.passed || !strings.Contains(failures(r), "missing") {
+		t.Errorf("compareFiles with a missing file compared %q: %q", pairs, failures(r))
+	}
+
+	r = resultsWithFiles(t, nil)
+	pairs = nil
+	r.compareFiles("test", 2, []string{"a"}, read, compare)
+	if pairs != nil || !r.passed {
+		t.Errorf("compareFiles with one file compared %q", pairs)
+	}
+}
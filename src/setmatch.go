@@ -0,0 +1,102 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+const (
+	setMaxLines = 20 // Missing or extra lines listed in a failure message
+)
+
+// A setMatch rule: files which must have the same lines, in any order
+type setRule struct {
+	Files            []string `json:"files"`
+	IgnoreDuplicates bool     `json:"ignoreDuplicates,omitempty"` // Only whether a line appears matters, not how many times
+}
+
+func (s *setRule) UnmarshalJSON(data []byte) error {
+	type plainRule setRule
+	return unmarshalRule(data, "setMatch", &s.Files, (*plainRule)(s))
+}
+
+func (s setRule) MarshalJSON() ([]byte, error) {
+	type plainRule setRule
+	return marshalRule(s.Files, s.IgnoreDuplicates, plainRule(s))
+}
+
+func (s setRule) String() string {
+	if s.IgnoreDuplicates {
+		return strings.Join(s.Files, ", ") + " (ignoring duplicates)"
+	}
+	return strings.Join(s.Files, ", ")
+}
+
+// The lines of a file, in the order they first appear, with how many times
+// each appears
+func countLines(text []byte) (lines []string, counts map[string]int) {
+	counts = make(map[string]int)
+	for _, line := range splitLines(text) {
+		line = strings.TrimSuffix(line, "\n")
+		if counts[line] == 0 {
+			lines = append(lines, line)
+		}
+		counts[line]++
+	}
+	return
+}
+
+// The lines of a which b has fewer of, each with how many are missing
+func (s setRule) missingLines(aLines []string, aCounts, bCounts map[string]int) (missing []string) {
+	for _, line := range aLines {
+		n := aCounts[line] - bCounts[line]
+		if s.IgnoreDuplicates && bCounts[line] > 0 {
+			n = 0
+		}
+		switch {
+		case n <= 0:
+		case n == 1 || s.IgnoreDuplicates:
+			missing = append(missing, strconv.Quote(line))
+		default:
+			missing = append(missing, strconv.Quote(line)+" ("+strconv.Itoa(n)+" times)")
+		}
+	}
+	return
+}
+
+func setLineList(heading string, lines []string) string {
+	if len(lines) > setMaxLines {
+		lines = append(lines[:setMaxLines], "... and "+strconv.Itoa(len(lines)-setMaxLines)+" more")
+	}
+	return "\n" + heading + ":\n  " + strings.Join(lines, "\n  ")
+}
+
+func (r *testResults) setMatch(index int, rule setRule) {
+	r.compareFiles("setMatch", index, rule.Files, readFileContent, func(aName, bName string, a, b interface{}) {
+		aText, bText := a.([]byte), b.([]byte)
+		expectedLines, expectedCounts := countLines(aText)
+		actualLines, actualCounts := countLines(bText)
+		missing := rule.missingLines(expectedLines, expectedCounts, actualCounts)
+		extra := rule.missingLines(actualLines, actualCounts, expectedCounts)
+		if missing == nil && extra == nil {
+			r.info("Files have the same lines: " + aName + ", " + bName)
+			return
+		}
+
+		msg := "Files don't have the same lines: " + aName + ", " + bName
+		if missing != nil {
+			msg += setLineList("Missing from "+bName, missing)
+		}
+		if extra != nil {
+			msg += setLineList("Extra in "+bName, extra)
+		}
+		// The diff is of the sorted files, so lines which are only out of
+		// order don't show up in it
+		sortLines := []normalizeStep{{Name: normalizeSortLines}}
+		aSorted, _ := normalize(aText, sortLines)
+		bSorted, _ := normalize(bText, sortLines)
+		r.diffs = append(r.diffs, unifiedDiff(aName, bName, aSorted, bSorted))
+		r.mismatches = append(r.mismatches, fileMismatch{rule: "setMatch", expected: aName, actual: bName, normalize: sortLines})
+		r.fail(msg)
+	})
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCountLines(t *testing.T) {
+	lines, counts := countLines([]byte("b\na\nb"))
+	if !reflect.DeepEqual(lines, []string{"b", "a"}) || !reflect.DeepEqual(counts, map[string]int{"a": 1, "b": 2}) {
+		t.Errorf("countLines = %q, %v", lines, counts)
+	}
+}
+
+func TestMissingLines(t *testing.T) {
+	tests := []struct {
+		rule             setRule
+		expected, actual string
+		missing, extra   []string
+	}{
+		{setRule{}, "a\nb\nc\n", "c\na\nb", nil, nil},
+		{setRule{}, "a\na\nb\n", "a\nb\nc\n", []string{`"a"`}, []string{`"c"`}},
+		{setRule{}, "a\na\na\n", "a\n", []string{`"a" (2 times)`}, nil},
+		{setRule{}, "a\n", "b\nb\n", []string{`"a"`}, []string{`"b" (2 times)`}},
+		{setRule{IgnoreDuplicates: true}, "a\na\nb\n", "b\na\n", nil, nil},
+		{setRule{IgnoreDuplicates: true}, "a\na\n", "b\n", []string{`"a"`}, []string{`"b"`}},
+	}
+	for _, tt := range tests {
+		expectedLines, expectedCounts := countLines([]byte(tt.expected))
+		actualLines, actualCounts := countLines([]byte(tt.actual))
+		missing := tt.rule.missingLines(expectedLines, expectedCounts, actualCounts)
+		extra := tt.rule.missingLines(actualLines, actualCounts, expectedCounts)
+		if !reflect.DeepEqual(missing, tt.missing) || !reflect.DeepEqual(extra, tt.extra) {
+			t.Errorf("%v missingLines(%q, %q) = %q, %q, want %q, %q", tt.rule, tt.expected, tt.actual, missing, extra, tt.missing, tt.extra)
+		}
+	}
+}
+
+func TestSetLineList(t *testing.T) {
+	var lines []string
+	for k := 0; k < setMaxLines+5; k++ {
+		lines = append(lines, strconv.Itoa(k))
+	}
+	list := setLineList("Missing", lines)
+	if !strings.HasPrefix(list, "\nMissing:\n  0\n  1\n") || !strings.HasSuffix(list, "\n  19\n  ... and 5 more") {
+		t.Errorf("setLineList of %d lines = %q", len(lines), list)
+	}
+}
+
+func TestSetMatch(t *testing.T) {
+	r := resultsWithFiles(t, map[string]string{"exp": "c\nb\na\n", "act": "a\nc\nb\n", "bad": "a\nb\nd\n"})
+	r.setMatch(0, setRule{Files: []string{"exp", "act"}})
+	if !r.passed {
+		t.Errorf("setMatch of reordered lines failed: %s", failures(r))
+	}
+
+	r.setMatch(0, setRule{Files: []string{"exp", "bad"}})
+	msg := failures(r)
+	if r.passed || !strings.Contains(msg, ":\n  \"c\"") || !strings.Contains(msg, ":\n  \"d\"") || len(r.mismatches) != 1 {
+		t.Errorf("setMatch of different lines = %q", msg)
+	}
+	// The diff is of the sorted files, so only the differing lines show up
+	diff := r.diffs[len(r.diffs)-1]
+	if !strings.Contains(diff, "\n-c\n") || !strings.HasSuffix(diff, "\n+d") || strings.Contains(diff, "\n-a\n") {
+		t.Errorf("setMatch diff = %q", diff)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"container/list"
+	"encoding/json"
+	"errors"
+	"hash/fnv"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	durationsFileName = "durations.json"
+)
+
+// Parse a shard specification of the form i/n, where 1 <= i <= n
+func parseShard(spec string) (index, count int, err error) {
+	parts := strings.Split(spec, "/")
+	if len(parts) != 2 {
+		return 0, 0, errors.New("Invalid shard (expected i/n): " + spec)
+	}
+	index, err1 := strconv.Atoi(parts[0])
+	count, err2 := strconv.Atoi(parts[1])
+	if err1 != nil || err2 != nil || count < 1 || index < 1 || index > count {
+		return 0, 0, errors.New("Invalid shard (expected i/n, with 1 <= i <= n): " + spec)
+	}
+	return index, count, nil
+}
+
+// Pick the tests belonging to shard index (1-based) of count. Tests are
+// hashed by name, so a test stays in the same shard as others are added.
+func hashShard(names []string, index, count int) (shard []string) {
+	shard = make([]string, 0)
+	for _, name := range names {
+		h := fnv.New32a()
+		h.Write([]byte(name))
+		if int(h.Sum32()%uint32(count)) == index-1 {
+			shard = append(shard, name)
+		}
+	}
+	return
+}
+
+// Pick the tests belonging to shard index (1-based) of count, balancing the
+// shards by the recorded test durations. The longest tests are placed
+// first, each in the shard with the least total time so far. Tests without
+// a recorded duration are assumed to take the average time.
+func balancedShard(names []string, durations map[string]float64, index, count int) (shard []string) {
+	average := 1.0
+	if len(durations) > 0 {
+		total := 0.0
+		for _, v := range durations {
+			total += v
+		}
+		average = total / float64(len(durations))
+	}
+	duration := func(name string) float64 {
+		if d, ok := durations[name]; ok {
+			return d
+		}
+		return average
+	}
+
+	sorted := make([]string, len(names))
+	copy(sorted, names)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		di, dj := duration(sorted[i]), duration(sorted[j])
+		if di != dj {
+			return di > dj
+		}
+		return sorted[i] < sorted[j]
+	})
+
+	loads := make([]float64, count)
+	inShard := make(map[string]bool)
+	for _, name := range sorted {
+		least := 0
+		for k, v := range loads {
+			if v < loads[least] {
+				least = k
+			}
+		}
+		loads[least] += duration(name)
+		if least == index-1 {
+			inShard[name] = true
+		}
+	}
+
+	// Keep the original order
+	shard = make([]string, 0)
+	for _, name := range names {
+		if inShard[name] {
+			shard = append(shard, name)
+		}
+	}
+	return
+}
+
+func durationsFile() string {
+	return stateDir + "/" + durationsFileName
+}
+
+// Load the recorded test durations, in seconds
+func loadDurations() (durations map[string]float64) {
+	durations = make(map[string]float64)
+	durationBytes, err := ioutil.ReadFile(durationsFile())
+	if err == nil {
+		json.Unmarshal(durationBytes, &durations)
+	}
+	return
+}
+
+// Record the durations of the tests which were run, keeping the recorded
+// durations of any others
+func saveDurations(tests *list.List) error {
+	durations := loadDurations()
+	for e := tests.Front(); e != nil; e = e.Next() {
+		currTest := e.Value.(*test)
+		if !currTest.results.skipped {
+			durations[currTest.testName] = currTest.results.duration.Seconds()
+		}
+	}
+	durationBytes, err := json.MarshalIndent(durations, "", "\t")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(stateDir, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(durationsFile(), append(durationBytes, '\n'), 0644)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"container/list"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+const (
+	lastRunFileName = "last-run.json"
+	statusPass      = "pass"
+	statusFail      = "fail"
+	statusSkip      = "skip"
+	statusXfail     = "xfail" // Failed, as expected
+)
+
+// The outcome of a test in the last run
+type testState struct {
+	Status   string   `json:"status"`
+	Duration float64  `json:"duration"` // Seconds
+	Failures []string `json:"failures,omitempty"`
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// What the last 'yoke run' did
+type runState struct {
+	Started  time.Time             `json:"started"`
+	Duration float64               `json:"duration"` // Seconds
+	Tests    map[string]*testState `json:"tests"`
+}
+
+func lastRunFile() string {
+	return stateDir + "/" + lastRunFileName
+}
+
+// Collect the messages from one of a testResults' lists
+func listStrings(l *list.List) (s []string) {
+	for e := l.Front(); e != nil; e = e.Next() {
+		s = append(s, e.Value.(string))
+	}
+	return
+}
+
+func (t *test) state() (s *testState) {
+	s = new(testState)
+	switch {
+	case t.results.skipped:
+		s.Status = statusSkip
+	case t.results.expectedFailure:
+		s.Status = statusXfail
+	case t.results.passed:
+		s.Status = statusPass
+	default:
+		s.Status = statusFail
+	}
+	s.Duration = t.results.duration.Seconds()
+	s.Failures = listStrings(t.results.errorList)
+	s.Warnings = listStrings(t.results.warningList)
+	return
+}
+
+func saveLastRun(tests *list.List, started time.Time, elapsed time.Duration) error {
+	state := runState{
+		Started:  started,
+		Duration: elapsed.Seconds(),
+		Tests:    make(map[string]*testState),
+	}
+	for e := tests.Front(); e != nil; e = e.Next() {
+		currTest := e.Value.(*test)
+		state.Tests[currTest.testName] = currTest.state()
+	}
+	stateBytes, err := json.MarshalIndent(state, "", "\t")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(stateDir, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(lastRunFile(), append(stateBytes, '\n'), 0644)
+}
+
+// Load what the last run did. If there was no last run, no tests are
+// returned.
+func loadLastRun() (state *runState) {
+	state = new(runState)
+	stateBytes, err := ioutil.ReadFile(lastRunFile())
+	if err == nil {
+		json.Unmarshal(stateBytes, state)
+	}
+	if state.Tests == nil {
+		state.Tests = make(map[string]*testState)
+	}
+	return
+}
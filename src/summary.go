@@ -0,0 +1,113 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	defaultPassExitCode  = 0
+	defaultFailExitCode  = 1
+	defaultErrorExitCode = 2
+)
+
+// Exit code policy from the configuration file
+type exitConfig struct {
+	Pass               *int  `json:"pass,omitempty"`
+	Fail               *int  `json:"fail,omitempty"`
+	Error              *int  `json:"error,omitempty"`
+	WarningsAsFailures *bool `json:"warningsAsFailures,omitempty"`
+}
+
+func (e *exitConfig) fixNullReferences() {
+	if e.Pass == nil {
+		newPass := defaultPassExitCode
+		e.Pass = &newPass
+	}
+	if e.Fail == nil {
+		newFail := defaultFailExitCode
+		e.Fail = &newFail
+	}
+	if e.Error == nil {
+		newError := defaultErrorExitCode
+		e.Error = &newError
+	}
+	if e.WarningsAsFailures == nil {
+		newWarningsAsFailures := false
+		e.WarningsAsFailures = &newWarningsAsFailures
+	}
+}
+
+// Totals for a run of the test suite
+type runSummary struct {
+	passed           int
+	failed           int
+	skipped          int
+	expectedFailures int
+	errored          bool // A test couldn't be loaded or was misconfigured
+	elapsed          time.Duration
+	failedTests      []string
+}
+
+func newSummary(tests *list.List, elapsed time.Duration) (s *runSummary) {
+	s = new(runSummary)
+	s.elapsed = elapsed
+	s.failedTests = make([]string, 0)
+	for e := tests.Front(); e != nil; e = e.Next() {
+		currTest := e.Value.(*test)
+		switch {
+		case currTest.results.skipped:
+			s.skipped++
+		case currTest.results.expectedFailure:
+			s.expectedFailures++
+		case currTest.results.passed:
+			s.passed++
+		default:
+			s.failed++
+			s.failedTests = append(s.failedTests, currTest.testName)
+		}
+		if currTest.results.misconfigured {
+			s.errored = true
+		}
+	}
+	return
+}
+
+// Pick the exit status for the run, according to the configured policy
+func (s *runSummary) exitCode() int {
+	if s.errored {
+		return *config.Exit.Error
+	}
+	if s.failed > 0 {
+		return *config.Exit.Fail
+	}
+	return *config.Exit.Pass
+}
+
+func (s *runSummary) totals() string {
+	total := s.passed + s.failed + s.skipped + s.expectedFailures
+	totals := fmt.Sprintf("Passed: %d, Failed: %d, Skipped: %d", s.passed, s.failed, s.skipped)
+	if s.expectedFailures > 0 {
+		totals += fmt.Sprintf(", Expected failures: %d", s.expectedFailures)
+	}
+	return totals + fmt.Sprintf(" (%d tests in %v)", total, s.elapsed.Round(time.Millisecond))
+}
+
+func (s *runSummary) print() {
+	fmt.Println()
+	fmt.Println(s.totals())
+	if len(s.failedTests) > 0 {
+		fmt.Println("Failed tests:")
+		fmt.Println("\t" + strings.Join(s.failedTests, "\n\t"))
+	}
+}
+
+// Print the summary as TAP comments
+func (s *runSummary) printTAP() {
+	fmt.Println("# " + s.totals())
+	for _, v := range s.failedTests {
+		fmt.Println("# Failed: " + v)
+	}
+}
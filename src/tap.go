@@ -0,0 +1,91 @@
+package main
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// Writes test results as a TAP (Test Anything Protocol) version 13 stream,
+// as each test finishes
+type tapReporter struct {
+	mu sync.Mutex
+	w  io.Writer
+	n  int // Number of the last test written
+}
+
+func (tap *tapReporter) suiteStart(tests *list.List) {
+	fmt.Fprintln(tap.w, "TAP version 13")
+	fmt.Fprintf(tap.w, "1..%d\n", tests.Len())
+}
+
+func (tap *tapReporter) testStart(t *test)                 {}
+func (tap *tapReporter) stepEnd(t *test, step *stepResult) {}
+
+func (tap *tapReporter) suiteEnd(tests *list.List, summary *runSummary) error {
+	summary.printTAP()
+	return nil
+}
+
+// Quote a string for YAML. Multi-line strings are written as literal blocks
+// indented by indent.
+func tapYAMLString(s, indent string) string {
+	if strings.Contains(s, "\n") {
+		return "|\n" + indent + strings.Replace(strings.TrimRight(s, "\n"), "\n", "\n"+indent, -1)
+	}
+	quoted, _ := json.Marshal(s) // JSON strings are valid YAML
+	return string(quoted)
+}
+
+// Write the result line (and diagnostics) of a finished test
+func (tap *tapReporter) testEnd(t *test) {
+	tap.mu.Lock()
+	defer tap.mu.Unlock()
+	tap.n++
+
+	status := "ok"
+	if !t.results.passed && !t.results.skipped {
+		status = "not ok"
+	}
+	line := fmt.Sprintf("%s %d - %s", status, tap.n, strings.Replace(t.testName, "#", "\\#", -1))
+	switch {
+	case t.results.skipped:
+		line += " # SKIP skipped by profile"
+	case t.rootProfile.ExpectFail != nil && *t.rootProfile.ExpectFail:
+		line += " # TODO expected to fail"
+	}
+	fmt.Fprintln(tap.w, line)
+
+	failures := listStrings(t.results.errorList)
+	warnings := listStrings(t.results.warningList)
+	if len(failures) == 0 && len(warnings) == 0 {
+		return
+	}
+	fmt.Fprintln(tap.w, "  ---")
+	if len(failures) > 0 {
+		fmt.Fprintln(tap.w, "  message: "+tapYAMLString(strings.SplitN(failures[0], "\n", 2)[0], ""))
+		fmt.Fprintln(tap.w, "  severity: fail")
+		fmt.Fprintln(tap.w, "  failures:")
+		for _, v := range failures {
+			fmt.Fprintln(tap.w, "    - "+tapYAMLString(v, "      "))
+		}
+	}
+	if len(warnings) > 0 {
+		fmt.Fprintln(tap.w, "  warnings:")
+		for _, v := range warnings {
+			fmt.Fprintln(tap.w, "    - "+tapYAMLString(v, "      "))
+		}
+	}
+	if len(t.results.mismatches) > 0 {
+		fmt.Fprintln(tap.w, "  mismatches:")
+		for _, v := range t.results.mismatches {
+			fmt.Fprintln(tap.w, "    - rule: "+v.rule)
+			fmt.Fprintln(tap.w, "      expected: "+tapYAMLString(v.expected, ""))
+			fmt.Fprintln(tap.w, "      actual: "+tapYAMLString(v.actual, ""))
+		}
+	}
+	fmt.Fprintln(tap.w, "  ...")
+}
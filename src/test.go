@@ -6,18 +6,28 @@ import (
 	"io"
 	"os"
 	"os/exec"
+	"strconv"
 	"sync"
 	"time"
 )
 
+// Whether before and after commands are printed as they run
+var echoCommands = true
+
+// Where the output of commands goes when the profile doesn't name a stdout
+// file. Machine-readable formats send it to stderr, to keep stdout parseable.
+var commandStdout io.Writer = os.Stdout
+
 type test struct {
-	testName string
-	done     bool
-	results  *testResults
-	stdin    io.Reader
-	stdout   io.Writer
-	stderr   io.Writer
-	profile  *testProfile
+	testName    string
+	done        bool
+	results     *testResults
+	stdin       io.Reader
+	stdout      io.Writer
+	stderr      io.Writer
+	profile     *testProfile // Profile for the step currently being run
+	rootProfile *testProfile // Profile for the first step (the start of the next chain)
+	reporter    reporter     // Told about the test as it runs (nil for nothing)
 }
 
 func newTest(name string) (t *test) {
@@ -28,6 +38,7 @@ func newTest(name string) (t *test) {
 
 	t.profile = newProfile(name, t.results)
 	t.profile.copyUnsetFrom(&config.DefaultProfile)
+	t.rootProfile = t.profile
 
 	t.results.info("Test loaded: " + name + " (config: " + *t.profile.Name + ")")
 
@@ -35,12 +46,18 @@ func newTest(name string) (t *test) {
 }
 
 func (t *test) run() {
+	start := time.Now()
+	t.results.startStep(t.profile)
 	t.checkRequiredFiles()
 	t.truncateOutputFiles()
 	t.runBeforeCommands()
 	t.runTestCommand()
 	t.parseResults()
 	t.runAfterCommands()
+	t.results.endStep(time.Since(start), t.excerpt(t.profile.Stdout), t.excerpt(t.profile.Stderr))
+	if t.reporter != nil {
+		t.reporter.stepEnd(t, t.results.currentStep())
+	}
 
 	if t.profile.Next != nil {
 		t.profile = t.profile.Next
@@ -48,9 +65,68 @@ func (t *test) run() {
 	}
 }
 
+// Run the test (unless it's skipped), timing it and applying the warning
+// policy from the configuration file
+func (t *test) execute() {
+	if t.reporter != nil {
+		t.reporter.testStart(t)
+		defer t.reporter.testEnd(t)
+	}
+	if t.rootProfile.Skip != nil && *t.rootProfile.Skip {
+		t.results.skipped = true
+		t.results.info("Test skipped")
+		t.results.emit(testEvent{Action: eventSkip})
+		t.done = true
+		return
+	}
+	t.results.emit(testEvent{Action: eventStart})
+	start := time.Now()
+	t.run()
+	t.results.duration = time.Since(start)
+
+	if *config.Exit.WarningsAsFailures && t.results.warningList.Len() > 0 {
+		t.results.fail("Warnings treated as failures")
+	}
+	if t.rootProfile.ExpectFail != nil && *t.rootProfile.ExpectFail {
+		if t.results.passed {
+			t.results.warn("Test passed, but was expected to fail")
+		} else {
+			t.results.expectedFailure = true
+			t.results.info("Test failed, as expected")
+		}
+	}
+
+	result := eventPass
+	if t.results.expectedFailure {
+		result = eventXfail
+	} else if !t.results.passed {
+		result = eventFail
+	}
+	t.results.emit(testEvent{Action: result, Elapsed: seconds(t.results.duration)})
+	t.done = true
+}
+
+// Read the start of one of the test's output files, for reports
+func (t *test) excerpt(filename *string) string {
+	if filename == nil {
+		return ""
+	}
+	f, err := os.Open(t.testName + "/" + *filename)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	buf := make([]byte, excerptSize+1)
+	n, _ := io.ReadFull(f, buf)
+	if n > excerptSize {
+		return string(buf[:excerptSize]) + "\n... (truncated)"
+	}
+	return string(buf[:n])
+}
+
 func (t *test) runInThread(c chan bool, wg *sync.WaitGroup) {
 	defer wg.Done()
-	t.run()
+	t.execute()
 	<-c // Done
 }
 
@@ -76,22 +152,17 @@ func (t *test) checkRequiredFiles() {
 }
 
 func (t *test) truncateOutputFiles() {
-	filename := t.testName + "/" + *t.profile.Stdout
-	f, err := os.OpenFile(filename, os.O_TRUNC, os.ModePerm)
-	defer f.Close()
-	if err != nil {
-		t.results.info("Unable to open " + *t.profile.Stdout + " for truncation: " + err.Error())
-	} else {
-		f.Truncate(0)
-	}
-
-	filename = t.testName + "/" + *t.profile.Stderr
-	f, err = os.OpenFile(filename, os.O_TRUNC, os.ModePerm)
-	defer f.Close()
-	if err != nil {
-		t.results.info("Unable to open " + *t.profile.Stderr + " for truncation: " + err.Error())
-	} else {
+	for _, v := range []*string{t.profile.Stdout, t.profile.Stderr} {
+		if v == nil { // Output goes to yoke's own stdout or stderr
+			continue
+		}
+		f, err := os.OpenFile(t.testName+"/"+*v, os.O_TRUNC, os.ModePerm)
+		if err != nil {
+			t.results.info("Unable to open " + *v + " for truncation: " + err.Error())
+			continue
+		}
 		f.Truncate(0)
+		f.Close()
 	}
 }
 
@@ -126,9 +197,9 @@ func (t *test) getStdio() (stdin io.Reader,
 	// Set up stdout limitwriter
 	if t.profile.Stdout == nil {
 		if t.profile.LimitOutput != nil {
-			stdout = limitWriter(os.Stdout, *t.profile.LimitOutput, t.results)
+			stdout = limitWriter(commandStdout, *t.profile.LimitOutput, t.results)
 		} else {
-			stdout = os.Stdout
+			stdout = commandStdout
 		}
 	} else {
 
@@ -173,7 +244,9 @@ func (t *test) getStdio() (stdin io.Reader,
 
 func (t *test) runCommands(commands []string) {
 	for _, command := range commands {
-		fmt.Println(command)
+		if echoCommands {
+			fmt.Println(command)
+		}
 
 		// // Run sh -c command
 		cmd := exec.Command("sh", "-c", command)
@@ -182,20 +255,10 @@ func (t *test) runCommands(commands []string) {
 		cmd.Stdin = stdin
 		cmd.Stdout = stdout
 		cmd.Stderr = stderr
-		err := cmd.Start()
+		err := t.startAndWait(cmd, command, t.results)
 		if err != nil {
 			t.results.fail("Error running " + command + ": " + err.Error())
 		}
-		time.AfterFunc(time.Duration(*t.profile.MaxTimePerCommand)*time.Second, func() {
-			command := command
-			cmd := cmd
-			if cmd.ProcessState != nil && !cmd.ProcessState.Exited() {
-				// Process is still running
-				cmd.Process.Kill()
-				t.results.exceededTimeLimit = append(t.results.exceededTimeLimit, command)
-			}
-		})
-		cmd.Wait()
 
 		// Close files
 		for _, v := range stdinFiles {
@@ -206,6 +269,50 @@ func (t *test) runCommands(commands []string) {
 	}
 }
 
+// Start a command and wait for it to finish, killing it if it runs past the
+// time limit. The command is recorded in r.
+func (t *test) startAndWait(cmd *exec.Cmd, command string, r *testResults) error {
+	start := time.Now()
+	r.emit(testEvent{Action: eventCommandStart, Command: command})
+	err := cmd.Start()
+	if err != nil {
+		r.emit(testEvent{Action: eventCommandEnd, Command: command, Elapsed: seconds(time.Since(start)), Message: err.Error()})
+		r.addCommand(commandResult{command, "", time.Since(start)})
+		return err
+	}
+
+	var timer *time.Timer
+	killed := make(chan bool, 1)
+	if t.profile.MaxTimePerCommand != nil && *t.profile.MaxTimePerCommand > 0 {
+		limit := time.Duration(*t.profile.MaxTimePerCommand) * time.Second
+		timer = time.AfterFunc(limit, func() {
+			if cmd.Process.Kill() == nil { // Process was still running
+				killed <- true
+				r.emit(testEvent{Action: eventTimeLimit, Command: command, Message: "Killed after " + limit.String()})
+			}
+		})
+	}
+	cmd.Wait()
+	if timer != nil {
+		timer.Stop()
+		select {
+		case <-killed:
+			r.exceededTimeLimit = append(r.exceededTimeLimit, command)
+		default:
+		}
+	}
+
+	e := testEvent{Action: eventCommandEnd, Command: command, Elapsed: seconds(time.Since(start))}
+	if cmd.ProcessState != nil {
+		exitCode := cmd.ProcessState.ExitCode()
+		e.ExitCode = &exitCode
+		e.ExitStatus = cmd.ProcessState.String()
+	}
+	r.emit(e)
+	r.addCommand(commandResult{command, e.ExitStatus, time.Since(start)})
+	return nil
+}
+
 func (t *test) runBeforeCommands() {
 	if t.profile.Before != nil {
 		t.runCommands(t.profile.Before)
@@ -228,23 +335,29 @@ func limitWriter(w io.Writer, n int64, r *testResults) io.Writer {
 
 func (l *limitedWriter) Write(p []byte) (n int, err error) {
 	if l.n <= 0 {
-		l.r.warn("Output limit reached")
-		l.r.limitReached = true
+		l.reachLimit()
 		return 0, errors.New("output limit reached")
 	}
 	if int64(len(p)) > l.n {
 		p = p[0:l.n]
-		l.r.warn("Output limit reached")
-		l.r.limitReached = true
+		l.reachLimit()
 	}
 	n, err = l.w.Write(p)
 	l.n -= int64(n)
 	return
 }
 
+func (l *limitedWriter) reachLimit() {
+	if !l.r.limitReached {
+		l.r.emit(testEvent{Action: eventOutputLimit, Message: "Output limit reached"})
+	}
+	l.r.warn("Output limit reached")
+	l.r.limitReached = true
+}
+
 func (t *test) runTestCommand() {
 	if t.profile.Command == nil {
-		t.results.fail("No test command specified")
+		t.results.configFail("No test command specified")
 		return
 	}
 	command := *t.profile.Command
@@ -254,20 +367,11 @@ func (t *test) runTestCommand() {
 	cmd.Stdin = stdin
 	cmd.Stdout = stdout
 	cmd.Stderr = stderr
-	cmd.Start()
-	if t.profile.MaxTimePerCommand != nil {
-		time.AfterFunc(time.Duration(*t.profile.MaxTimePerCommand)*time.Second, func() {
-			command := command
-			cmd := cmd
-			if cmd.ProcessState != nil && !cmd.ProcessState.Exited() {
-				// Process is still running
-				cmd.Process.Kill()
-				t.results.exceededTimeLimit = append(t.results.exceededTimeLimit, command)
-			}
-		})
-	}
-	cmd.Wait()
+	t.startAndWait(cmd, command, t.results)
 	t.results.cmd = cmd
+	if cmd.ProcessState != nil {
+		t.results.currentStep().exitStatus = cmd.ProcessState.String()
+	}
 
 	// Close files
 	for _, v := range stdinFiles {
@@ -282,56 +386,122 @@ func (t *test) parseResults() {
 		t.results.info("No pass conditions specified")
 		return
 	}
+	t.checkConditions(t.profile.Pass, t.results)
+}
 
-	match := t.profile.Pass.Match
+// Check a set of pass conditions, recording the outcome in r. Every
+// condition in the set (including each group) has to pass.
+func (t *test) checkConditions(pass *passConditions, r *testResults) {
+	if pass == nil { // A null branch, which is reported when the profile is loaded
+		return
+	}
+	match := pass.Match
 	if match != nil {
 		for k, v := range match {
-			t.results.match(k, v)
+			r.match(k, v)
 		}
 	}
 
-	rmatch := t.profile.Pass.Rmatch
+	rmatch := pass.Rmatch
 	if rmatch != nil {
 		for k, v := range rmatch {
-			t.results.rmatch(k, v)
+			r.rmatch(k, v)
 		}
 	}
 
-	if t.profile.Pass.ZeroExit != nil {
-		zeroExit := *t.profile.Pass.ZeroExit
-		if zeroExit {
-			if !t.results.cmd.ProcessState.Success() {
-				t.results.fail("Non-zero exit status (zero expected)")
+	for k, v := range pass.JSONMatch {
+		r.jsonMatch(k, v)
+	}
+
+	for k, v := range pass.NumericMatch {
+		r.numericMatch(k, v)
+	}
+
+	for k, v := range pass.SetMatch {
+		r.setMatch(k, v)
+	}
+
+	if pass.ZeroExit != nil {
+		zeroExit := *pass.ZeroExit
+		if r.cmd == nil || r.cmd.ProcessState == nil {
+			r.fail("No exit status, since the test command didn't run")
+		} else if zeroExit {
+			if !r.cmd.ProcessState.Success() {
+				r.fail("Non-zero exit status (zero expected)")
 			}
 		} else {
-			if t.results.cmd.ProcessState.Success() {
-				t.results.fail("Zero exit status (non-zero expected)")
+			if r.cmd.ProcessState.Success() {
+				r.fail("Zero exit status (non-zero expected)")
 			}
 		}
 	}
 
-	if t.profile.Pass.LimitReached != nil {
-		limitReached := *t.profile.Pass.LimitReached
-		if !limitReached && t.results.limitReached {
-			t.results.fail("Output limit reached")
-		} else if limitReached && !t.results.limitReached {
-			t.results.fail("Output limit not reached")
+	if len(pass.ExitCode) > 0 || pass.Signal != nil {
+		var state *os.ProcessState
+		if r.cmd != nil {
+			state = r.cmd.ProcessState
 		}
+		r.checkExitStatus(state, pass.ExitCode, pass.Signal)
 	}
 
-	if t.profile.Pass.MaxTimePerCommandReached != nil {
-		mtpcReached := *t.profile.Pass.MaxTimePerCommandReached
+	if pass.LimitReached != nil {
+		limitReached := *pass.LimitReached
+		if !limitReached && r.limitReached {
+			r.fail("Output limit reached")
+		} else if limitReached && !r.limitReached {
+			r.fail("Output limit not reached")
+		}
+	}
+
+	if pass.MaxTimePerCommandReached != nil {
+		mtpcReached := *pass.MaxTimePerCommandReached
 		if mtpcReached {
-			for _, v := range t.results.exceededTimeLimit {
-				t.results.fail("Command time limit reached: " + v)
+			for _, v := range r.exceededTimeLimit {
+				r.fail("Command time limit reached: " + v)
 			}
 		} else {
-			for _, v := range t.results.exceededTimeLimit {
-				t.results.fail("Command time limit not reached: " + v)
+			for _, v := range r.exceededTimeLimit {
+				r.fail("Command time limit not reached: " + v)
 			}
 		}
 	}
 
+	for _, v := range pass.Check {
+		t.runCheck(v, r)
+	}
+
+	for k, v := range pass.AllOf {
+		branch := r.branch()
+		t.checkConditions(v, branch)
+		r.mergeBranch(branch, "allOf branch "+strconv.Itoa(k+1)+" failed: ", true)
+	}
+
+	if pass.AnyOf != nil {
+		branches := make([]*testResults, 0, len(pass.AnyOf))
+		anyPassed := false
+		for _, v := range pass.AnyOf {
+			branch := r.branch()
+			t.checkConditions(v, branch)
+			branches = append(branches, branch)
+			anyPassed = anyPassed || branch.passed
+		}
+		if len(branches) == 0 {
+			r.fail("No anyOf branch passed (there are none)")
+		}
+		// Only report why the branches failed if none of them passed
+		for k, v := range branches {
+			r.mergeBranch(v, "anyOf branch "+strconv.Itoa(k+1)+" failed: ", !anyPassed)
+		}
+	}
+
+	if pass.Not != nil {
+		branch := r.branch()
+		t.checkConditions(pass.Not, branch)
+		r.mergeBranch(branch, "", false)
+		if branch.passed {
+			r.fail("The conditions under not passed (expected them to fail)")
+		}
+	}
 }
 
 func (t *test) runAfterCommands() {
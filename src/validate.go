@@ -0,0 +1,473 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var unmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// A problem found in a configuration or profile file. line and col are zero
+// when the problem isn't tied to a position in the file.
+type validationError struct {
+	file string
+	line int
+	col  int
+	msg  string
+}
+
+func (e validationError) String() string {
+	if e.line == 0 {
+		return e.file + ": " + e.msg
+	}
+	return e.file + ":" + strconv.Itoa(e.line) + ":" + strconv.Itoa(e.col) + ": " + e.msg
+}
+
+// Walks a JSON document, checking it against the fields and types of a Go
+// struct. Unlike json.Unmarshal, field names must match exactly and unknown
+// fields are reported.
+type schemaValidator struct {
+	file   string
+	data   []byte
+	dec    *json.Decoder
+	broken bool // A syntax error was found, so nothing more can be checked
+	errs   []validationError
+}
+
+// Check a JSON document against the type of v
+func validateSchema(file string, data []byte, v interface{}) []validationError {
+	s := &schemaValidator{file: file, data: data}
+	s.dec = json.NewDecoder(bytes.NewReader(data))
+	s.dec.UseNumber()
+	s.value(reflect.TypeOf(v))
+	if !s.broken {
+		if _, err := s.dec.Token(); err != io.EOF {
+			s.errorAt(s.nextStart(), "unexpected data after the end of the JSON value")
+		}
+	}
+	return s.errs
+}
+
+func (s *schemaValidator) errorAt(offset int64, msg string) {
+	line, col := 1, 1
+	for _, c := range s.data[:offset] {
+		if c == '\n' {
+			line++
+			col = 1
+		} else {
+			col++
+		}
+	}
+	s.errs = append(s.errs, validationError{s.file, line, col, msg})
+}
+
+// Offset of the start of the next token
+func (s *schemaValidator) nextStart() int64 {
+	i := s.dec.InputOffset()
+	for i < int64(len(s.data)) && strings.IndexByte(" \t\r\n:,", s.data[i]) >= 0 {
+		i++
+	}
+	return i
+}
+
+func (s *schemaValidator) token() (tok json.Token, start int64, ok bool) {
+	start = s.nextStart()
+	tok, err := s.dec.Token()
+	if err != nil {
+		if syntaxErr, isSyntax := err.(*json.SyntaxError); isSyntax && syntaxErr.Offset > 0 {
+			start = syntaxErr.Offset - 1 // The offset is just after the bad character
+		}
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		s.errorAt(start, err.Error())
+		s.broken = true
+		return nil, start, false
+	}
+	return tok, start, true
+}
+
+// Skip the rest of an object or array whose opening delimiter has been read
+func (s *schemaValidator) skipComposite() {
+	for depth := 1; depth > 0; {
+		tok, _, ok := s.token()
+		if !ok {
+			return
+		}
+		if d, isDelim := tok.(json.Delim); isDelim {
+			if d == '{' || d == '[' {
+				depth++
+			} else {
+				depth--
+			}
+		}
+	}
+}
+
+func jsonFieldName(f reflect.StructField) string {
+	name := strings.Split(f.Tag.Get("json"), ",")[0]
+	if name == "" {
+		return f.Name
+	}
+	return name
+}
+
+func jsonTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Struct, reflect.Map:
+		return "object"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return "integer"
+	}
+	return "number"
+}
+
+// Check the next value against type t
+func (s *schemaValidator) value(t reflect.Type) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	// Types with their own JSON parsing are checked by unmarshaling them
+	if reflect.PtrTo(t).Implements(unmarshalerType) {
+		start := s.nextStart()
+		var raw json.RawMessage
+		if err := s.dec.Decode(&raw); err != nil {
+			s.errorAt(start, err.Error())
+			s.broken = true
+			return
+		}
+		if err := json.Unmarshal(raw, reflect.New(t).Interface()); err != nil {
+			s.errorAt(start, err.Error())
+		}
+		return
+	}
+
+	tok, start, ok := s.token()
+	if !ok || tok == nil { // null is allowed anywhere
+		return
+	}
+	delim, isDelim := tok.(json.Delim)
+	mismatch := func() {
+		s.errorAt(start, "expected "+jsonTypeName(t))
+		if isDelim {
+			s.skipComposite()
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if !isDelim || delim != '{' {
+			mismatch()
+			return
+		}
+		fields := make(map[string]reflect.StructField)
+		for i := 0; i < t.NumField(); i++ {
+			fields[jsonFieldName(t.Field(i))] = t.Field(i)
+		}
+		for !s.broken && s.dec.More() {
+			keyTok, keyStart, ok := s.token()
+			if !ok {
+				return
+			}
+			key := keyTok.(string)
+			field, known := fields[key]
+			if !known || jsonFieldName(field) == "-" {
+				s.errorAt(keyStart, "unknown field \""+key+"\"")
+				s.skipValue()
+				continue
+			}
+			s.value(field.Type)
+		}
+		s.token() // }
+	case reflect.Map:
+		if !isDelim || delim != '{' {
+			mismatch()
+			return
+		}
+		for !s.broken && s.dec.More() {
+			if _, _, ok := s.token(); !ok {
+				return
+			}
+			s.value(t.Elem())
+		}
+		s.token() // }
+	case reflect.Slice:
+		if !isDelim || delim != '[' {
+			mismatch()
+			return
+		}
+		for !s.broken && s.dec.More() {
+			s.value(t.Elem())
+		}
+		s.token() // ]
+	case reflect.String:
+		if _, isString := tok.(string); !isString {
+			mismatch()
+		}
+	case reflect.Bool:
+		if _, isBool := tok.(bool); !isBool {
+			mismatch()
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, isNumber := tok.(json.Number)
+		if !isNumber {
+			mismatch()
+		} else if _, err := n.Int64(); err != nil {
+			mismatch()
+		}
+	case reflect.Float32, reflect.Float64:
+		if _, isNumber := tok.(json.Number); !isNumber {
+			mismatch()
+		}
+	default:
+		if isDelim {
+			s.skipComposite()
+		}
+	}
+}
+
+// Skip over the next value, whatever it is
+func (s *schemaValidator) skipValue() {
+	tok, _, ok := s.token()
+	if !ok {
+		return
+	}
+	if d, isDelim := tok.(json.Delim); isDelim && (d == '{' || d == '[') {
+		s.skipComposite()
+	}
+}
+
+// Check the settings of each step of a profile, which can't be caught by
+// the schema
+func checkProfile(file, testdir string, p *testProfile) (errs []validationError) {
+	produced := make(map[string]bool) // Files written by earlier steps
+	for step := 0; p != nil; step, p = step+1, p.Next {
+		prefix := ""
+		if step > 0 {
+			prefix = "next step " + strconv.Itoa(step) + ": "
+		}
+		problem := func(msg string) {
+			errs = append(errs, validationError{file: file, msg: prefix + msg})
+		}
+
+		if p.Command == nil {
+			problem("no test command specified")
+		}
+		created := make(map[string]bool)
+		if p.CreateRequired != nil && *p.CreateRequired {
+			for _, v := range p.RequiredFiles {
+				created[v] = true
+			}
+		}
+		for _, v := range p.Stdin {
+			if produced[v] || created[v] {
+				continue
+			}
+			if _, err := os.Stat(testdir + "/" + v); err != nil {
+				problem("stdin file doesn't exist: " + v)
+			}
+		}
+		for _, msg := range checkPass(testdir, p.Pass) {
+			problem(msg)
+		}
+
+		if p.Stdout != nil {
+			produced[*p.Stdout] = true
+		}
+		if p.Stderr != nil {
+			produced[*p.Stderr] = true
+		}
+	}
+	return
+}
+
+// Check the rules in a set of pass conditions
+func checkPass(testdir string, pass *passConditions) (msgs []string) {
+	if pass == nil {
+		return
+	}
+	for _, v := range pass.ExitCode {
+		if v < 0 || v > 255 {
+			msgs = append(msgs, "exitCode "+strconv.Itoa(v)+" is out of range (0-255)")
+		}
+	}
+	if pass.ExitCode != nil && len(pass.ExitCode) == 0 {
+		msgs = append(msgs, "exitCode is an empty list")
+	}
+	if pass.Signal != nil {
+		if _, err := parseSignal(*pass.Signal); err != nil {
+			msgs = append(msgs, "unknown signal: "+*pass.Signal)
+		}
+	}
+	if pass.ZeroExit != nil && (len(pass.ExitCode) > 0 || pass.Signal != nil) {
+		msgs = append(msgs, "zeroExit is checked as well as exitCode and signal; leave it out")
+	}
+	for k, v := range pass.Match {
+		if len(v.Files) < 2 {
+			msgs = append(msgs, "match rule "+strconv.Itoa(k)+" has fewer than two files")
+		}
+		if v.Mode != "" {
+			msgs = append(msgs, "match rule "+strconv.Itoa(k)+" has a mode, which only rmatch rules have")
+		}
+	}
+	for _, rules := range [][]fileRule{pass.Match, pass.Rmatch} {
+		for _, rule := range rules {
+			for _, step := range rule.Normalize {
+				if err := step.check(); err != nil {
+					msgs = append(msgs, err.Error())
+				}
+			}
+		}
+	}
+	for k, v := range pass.Rmatch {
+		if len(v.Files) < 2 {
+			msgs = append(msgs, "rmatch rule "+strconv.Itoa(k)+" has fewer than two files")
+		}
+		if err := v.checkMode(); err != nil {
+			msgs = append(msgs, "rmatch rule "+strconv.Itoa(k)+" has an "+err.Error())
+		}
+		if len(v.Files) < 1 {
+			continue
+		}
+		reBytes, err := ioutil.ReadFile(testdir + "/" + v.Files[0])
+		if err != nil {
+			msgs = append(msgs, "unable to read regular expression file: "+v.Files[0])
+			continue
+		}
+		if _, err := compileRmatch(string(reBytes), v.Mode); err != nil {
+			msgs = append(msgs, "regular expression file doesn't compile: "+v.Files[0]+": "+err.Error())
+		}
+	}
+	for k, v := range pass.JSONMatch {
+		if len(v.Files) < 2 {
+			msgs = append(msgs, "jsonMatch rule "+strconv.Itoa(k)+" has fewer than two files")
+		}
+		if err := v.check(); err != nil {
+			msgs = append(msgs, "jsonMatch rule "+strconv.Itoa(k)+": "+err.Error())
+		}
+	}
+	for k, v := range pass.NumericMatch {
+		if len(v.Files) < 2 {
+			msgs = append(msgs, "numericMatch rule "+strconv.Itoa(k)+" has fewer than two files")
+		}
+		if err := v.check(); err != nil {
+			msgs = append(msgs, "numericMatch rule "+strconv.Itoa(k)+": "+err.Error())
+		}
+	}
+	for k, v := range pass.SetMatch {
+		if len(v.Files) < 2 {
+			msgs = append(msgs, "setMatch rule "+strconv.Itoa(k)+" has fewer than two files")
+		}
+	}
+	for k, v := range pass.Check {
+		if strings.TrimSpace(v) == "" {
+			msgs = append(msgs, "check command "+strconv.Itoa(k)+" is empty")
+		}
+	}
+	if pass.AnyOf != nil && len(pass.AnyOf) == 0 {
+		msgs = append(msgs, "anyOf is an empty list, so it can never pass")
+	}
+	for k, v := range pass.AnyOf {
+		if v == nil {
+			msgs = append(msgs, "anyOf branch "+strconv.Itoa(k+1)+" is null")
+		}
+		for _, msg := range checkPass(testdir, v) {
+			msgs = append(msgs, "anyOf branch "+strconv.Itoa(k+1)+": "+msg)
+		}
+	}
+	for k, v := range pass.AllOf {
+		if v == nil {
+			msgs = append(msgs, "allOf branch "+strconv.Itoa(k+1)+" is null")
+		}
+		for _, msg := range checkPass(testdir, v) {
+			msgs = append(msgs, "allOf branch "+strconv.Itoa(k+1)+": "+msg)
+		}
+	}
+	for _, msg := range checkPass(testdir, pass.Not) {
+		msgs = append(msgs, "not: "+msg)
+	}
+	return
+}
+
+// Validate the configuration file, printing any problems found. Returns
+// false if there were any. This is done before the configuration is loaded,
+// so that syntax errors are reported with their line and column.
+func validateConfig() bool {
+	var errs []validationError
+	configBytes, err := ioutil.ReadFile(defaultConfigFile)
+	if err != nil {
+		errs = append(errs, validationError{file: defaultConfigFile, msg: err.Error()})
+	} else {
+		errs = validateSchema(defaultConfigFile, configBytes, yokeConfig{})
+	}
+	for _, v := range errs {
+		fmt.Fprintln(os.Stderr, v.String())
+	}
+	return len(errs) == 0
+}
+
+// Validate the profiles of the named tests (or all tests) against the loaded
+// configuration, printing any problems found. Returns false if there were
+// any.
+func validateTests(names []string) bool {
+	errs := make([]validationError, 0)
+
+	found, err := findTests(names, false)
+	if err != nil {
+		errs = append(errs, validationError{file: strings.Join(names, " "), msg: err.Error()})
+	}
+	for _, name := range found {
+		filename := name + "/" + profileFileName
+		p := new(testProfile)
+		profileBytes, err := ioutil.ReadFile(filename)
+		if err != nil {
+			filename = name + " (default profile)"
+		} else {
+			schemaErrs := validateSchema(filename, profileBytes, testProfile{})
+			errs = append(errs, schemaErrs...)
+			// Type errors have already been reported, and the rest of the
+			// profile is still worth checking
+			err := json.Unmarshal(profileBytes, p)
+			if _, isSyntax := err.(*json.SyntaxError); isSyntax {
+				continue
+			}
+		}
+		p.copyUnsetFrom(&config.DefaultProfile)
+		errs = append(errs, checkProfile(filename, name, p)...)
+	}
+
+	for _, v := range errs {
+		fmt.Fprintln(os.Stderr, v.String())
+	}
+	return len(errs) == 0
+}
+
+func validateCommand(args []string) int {
+	validateFlags := flag.NewFlagSet("validate", flag.ExitOnError)
+	validateFlags.Parse(args)
+
+	if !validateConfig() {
+		return defaultErrorExitCode
+	}
+	loadConfig()
+	if !validateTests(validateFlags.Args()) {
+		return *config.Exit.Error
+	}
+	fmt.Println("No problems found")
+	return *config.Exit.Pass
+}
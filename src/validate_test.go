@@ -0,0 +1,21 @@
+package main
+
+import "testing"
+
+func TestValidateSchemaSyntaxError(t *testing.T) {
+	errs := validateSchema(defaultConfigFile, []byte("{\n\t\"prefix\": \"test-\",,\n}"), yokeConfig{})
+	if len(errs) != 1 || errs[0].line != 2 || errs[0].col != 20 {
+		t.Errorf("validateSchema of a config with a syntax error = %v, want an error at 2:20", errs)
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	chdirWithFiles(t, map[string]string{defaultConfigFile: `{"prefix": "test-", "maxthreads": 1,}`})
+	if validateConfig() {
+		t.Errorf("validateConfig of a config with a syntax error passed")
+	}
+	chdirWithFiles(t, map[string]string{defaultConfigFile: `{"prefix": "test-", "maxthreads": 1}`})
+	if !validateConfig() {
+		t.Errorf("validateConfig of a valid config failed")
+	}
+}
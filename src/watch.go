@@ -0,0 +1,159 @@
+package main
+
+import (
+	"container/list"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	defaultWatchInterval = time.Second
+)
+
+// The state of a file when it was last checked
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// Record the state of a file or, for a directory, every file in it.
+// Missing paths are recorded as missing, so their creation is noticed.
+func stampPath(path string, stamps map[string]fileStamp) {
+	filepath.Walk(path, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			stamps[p] = fileStamp{}
+			return nil
+		}
+		if !fi.IsDir() {
+			stamps[p] = fileStamp{fi.ModTime(), fi.Size()}
+		}
+		return nil
+	})
+}
+
+func stampsEqual(a, b map[string]fileStamp) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		w, ok := b[k]
+		if !ok || !v.modTime.Equal(w.modTime) || v.size != w.size {
+			return false
+		}
+	}
+	return true
+}
+
+// Record the state of everything a test depends on: its directory (which
+// holds its profile, inputs and expected files) and the extra paths in the
+// watch lists of its profile
+func (t *test) stamp() (stamps map[string]fileStamp) {
+	stamps = make(map[string]fileStamp)
+	stampPath(t.testName, stamps)
+	for p := t.rootProfile; p != nil; p = p.Next {
+		for _, v := range p.Watch {
+			stampPath(v, stamps)
+		}
+	}
+	return
+}
+
+// A test being watched, with the state of its files when it was loaded or
+// last run
+type watchedTest struct {
+	t      *test
+	stamps map[string]fileStamp
+}
+
+func watchTests(args []string) {
+	watchFlags := flag.NewFlagSet("watch", flag.ExitOnError)
+
+	interval := watchFlags.Duration("interval", defaultWatchInterval, "how often to check for changes")
+	paths := watchFlags.String("paths", "", "comma-separated list of extra paths (e.g., the program being tested) which affect every test")
+	showInfo := watchFlags.Bool("info", false, "show info output")
+	showWarnings := watchFlags.Bool("warnings", false, "show warnings")
+	var filter testFilter
+	filter.addFlags(watchFlags)
+	watchFlags.Parse(args)
+
+	err := filter.compile()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(*config.Exit.Error)
+	}
+
+	// Stamps are taken after each test runs, so the files a test writes
+	// don't count as changes. Profiles are only loaded again when a test's
+	// files change.
+	watched := make(map[string]*watchedTest)
+	extraStamps := make(map[string]fileStamp)
+	for _, v := range splitList(*paths) {
+		stampPath(v, extraStamps)
+	}
+
+	fmt.Println("Watching for changes. Press Ctrl-C to stop.")
+	for first := true; ; first = false {
+		if !first {
+			time.Sleep(*interval)
+		}
+
+		newExtraStamps := make(map[string]fileStamp)
+		for _, v := range splitList(*paths) {
+			stampPath(v, newExtraStamps)
+		}
+		extraChanged := !stampsEqual(extraStamps, newExtraStamps)
+		extraStamps = newExtraStamps
+
+		found, _ := findTests(watchFlags.Args(), false)
+		present := make(map[string]bool)
+		changed := make(map[string]bool)
+		names := make([]string, 0, len(found))
+		for _, name := range found {
+			if !filter.matchName(name) {
+				continue
+			}
+			present[name] = true
+			w, seen := watched[name]
+			if first || extraChanged || !seen || !stampsEqual(w.stamps, w.t.stamp()) {
+				w = &watchedTest{t: newTest(name)}
+				w.stamps = w.t.stamp()
+				watched[name] = w
+				changed[name] = true
+			}
+			if filter.matchProfile(w.t.rootProfile) {
+				names = append(names, name)
+			}
+		}
+		for name := range watched {
+			if !present[name] {
+				delete(watched, name)
+			}
+		}
+		tests := list.New()
+		for _, name := range filter.selectShard(names) {
+			if changed[name] {
+				tests.PushBack(watched[name].t)
+			}
+		}
+		if tests.Len() == 0 {
+			continue
+		}
+
+		fmt.Printf("[%s] Running %d changed test(s)\n", time.Now().Format("15:04:05"), tests.Len())
+		text := newTextReporter(*showWarnings, *showInfo)
+		echoCommands = !text.live // Commands would break up the status line
+		reporters := reporterList{text}
+		start := time.Now()
+		reporters.suiteStart(tests)
+		runTestList(tests, reporters)
+		saveAllDiffs(tests)
+		for e := tests.Front(); e != nil; e = e.Next() {
+			w := watched[e.Value.(*test).testName]
+			w.stamps = w.t.stamp()
+		}
+		reporters.suiteEnd(tests, newSummary(tests, time.Since(start)))
+	}
+}
@@ -3,46 +3,100 @@ package main
 import (
 	"container/list"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
 	"runtime"
 	"strings"
 	"sync"
+	"time"
 )
 
 const (
 	defaultConfigFile = "yoke_config.json"
+	stateDir          = ".yoke" // Directory for state saved between runs
 )
 
-var config struct {
-	DefaultProfile testProfile `json:"defaultProfile"`
-	Maxthreads     int         `json:maxthreads`
-	Prefix         string      `json:"prefix"`
+type yokeConfig struct {
+	DefaultProfile testProfile              `json:"defaultProfile"`
+	Diff           diffConfig               `json:"diff"`
+	Exit           exitConfig               `json:"exit"`
+	Golden         string                   `json:"golden,omitempty"`
+	Maxthreads     int                      `json:"maxthreads,omitempty"` // 0 for the number of CPUs
+	Prefix         string                   `json:"prefix"`
+	Reporters      []externalReporterConfig `json:"reporters,omitempty"`
+	Templates      map[string]testProfile   `json:"templates,omitempty"`
 }
 
-func main() {
+var config yokeConfig
 
-	loadConfig()
+func main() {
 
 	command, args := parseArgs(os.Args)
 	// fmt.Printf("command: %s\nargs: %v\n", command, args)
 
+	// There's no configuration to load until init has written one, and
+	// validate (and run -validate) check it before loading it
+	if command != "init" && command != "validate" && command != "run" {
+		loadConfig()
+	}
+
 	switch command {
 	case "help":
 		printUsage(args)
 		os.Exit(0)
 	case "run":
-		runTests(args)
+		os.Exit(runTests(args))
+	case "create":
+		err := createTest(args)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
 		os.Exit(0)
-	case "create": // TODO
-		fmt.Println("Create is not yet implemented. Sorry.")
-		os.Exit(1)
+	case "accept":
+		err := acceptTests(args)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	case "diff":
+		err := showDiffs(args)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	case "init":
+		err := initConfig(args)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	case "profile":
+		err := showProfile(args)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	case "validate":
+		os.Exit(validateCommand(args))
 	case "list":
-		listTests(args)
+		err := listTests(args)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
 		os.Exit(0)
+	case "watch":
+		watchTests(args)
 	case "version":
 		// TODO: Do this dynamically, rather than hard-coding the version number
 		fmt.Println("Yoke v0.9 by mhweaver")
@@ -62,10 +116,16 @@ func printUsage(args []string) {
 		fmt.Println()
 		fmt.Println("If a command is not specified, all tests are run (this is the same is running 'yoke run')")
 		fmt.Println("Commands (for more information, 'yoke help [command]' is your friend):")
-		fmt.Println("\tyoke create\tNot yet implemented. Create a new test")
+		fmt.Println("\tyoke accept\tAccept the actual output of failing tests as the expected output")
+		fmt.Println("\tyoke create\tCreate a new test")
+		fmt.Println("\tyoke diff\tShow the differences found in the last run of a test")
 		fmt.Println("\tyoke help\tView usage information for a command")
+		fmt.Println("\tyoke init\tGenerate a yoke configuration file")
 		fmt.Println("\tyoke list\tList recognized tests. Does not run tests")
+		fmt.Println("\tyoke profile\tShow a test's profile")
 		fmt.Println("\tyoke run\tRun tests")
+		fmt.Println("\tyoke validate\tCheck the configuration file and test profiles for problems")
+		fmt.Println("\tyoke watch\tRerun tests when the files they depend on change")
 		fmt.Println("\tyoke version\tShow version information")
 		return
 	}
@@ -82,21 +142,98 @@ func printUsage(args []string) {
 		fmt.Println("Runs the specified tests.")
 		fmt.Println("If no tests are all given, all tests will be run.")
 		fmt.Println("To see a list of all tests, use 'yoke list'")
+		fmt.Println("Tests can be selected by name with -run and -skip (regular expressions), and by the tags in their profiles with -tags and -exclude-tags (comma-separated lists).")
+		fmt.Println("Results are printed as each test finishes. When stdout is a terminal, a status line shows the running tests and the counts so far.")
+		fmt.Println("With -format tap, results are written as a Test Anything Protocol (version 13) stream as each test finishes.")
+		fmt.Println("With -json (or -format json), events are written as newline-delimited JSON as they happen: tests and commands starting and finishing, output and time limits being reached, messages, and test results.")
+		fmt.Println("With -format tap or json, the output of tests whose profile doesn't set \"stdout\" goes to stderr (as do the -verbose messages), so it doesn't mix with the results.")
+		fmt.Println("Tests whose profile sets \"expectFail\" are expected to fail, and don't count as failures when they do.")
+		fmt.Println("Reports can be written with -report kind=path (e.g., -report junit=results.xml). Report kinds: " + reportKinds() + ".")
+		fmt.Println("The external reporters listed under \"reporters\" in the configuration file are run alongside the tests, and get the same events as -json on their stdin.")
+		fmt.Println("The html report is a single self-contained file, with each test's resolved profile, commands, output and side-by-side diffs.")
+		fmt.Println("-failed selects the tests which failed in the last run, and -new selects the tests which have never been run.")
+		fmt.Println("The outcome of each test in the last run is saved in " + stateDir + "/" + lastRunFileName + ".")
+		fmt.Println("To split the tests across several machines, use -shard i/n to run shard i of n.")
+		fmt.Println("Tests are assigned to shards by name, or with -shard-balance, by the durations recorded in " + stateDir + "/" + durationsFileName + " (every shard must see the same file).")
+		fmt.Println("Tests whose profile sets \"skip\" are counted as skipped and not run.")
+		fmt.Println()
+		fmt.Println("Exit status is 0 if all tests pass, 1 if any test fails, and 2 if a test couldn't be loaded or was misconfigured.")
+		fmt.Println("These codes, and whether warnings count as failures, are set in the \"exit\" section of the yoke configuration file.")
+		fmt.Println()
+		fmt.Println("For flags, see 'yoke", args[0], "-h'")
+	case "accept":
+		fmt.Println("Usage:")
+		fmt.Println("\tyoke accept [flags] [test1 [test2 [...]]]")
+		fmt.Println()
+		fmt.Println("Runs the specified tests (or all tests) and, for each failing test, overwrites the golden file in each match, jsonMatch, numericMatch and setMatch rule (including those in anyOf and allOf groups) with the actual file next to it.")
+		fmt.Println("Rules with more than two files, and rules under not, are left alone.")
+		fmt.Println("Which file in a rule is the golden is set by the \"golden\" setting in the yoke configuration file, or the -golden flag:")
+		fmt.Println("\tfirst\tthe first file is the golden (default)")
+		fmt.Println("\tlast\tthe last file is the golden")
+		fmt.Println("\t[suffix]\tthe file ending with [suffix] (e.g., .expected) is the golden")
 		fmt.Println()
 		fmt.Println("For flags, see 'yoke", args[0], "-h'")
 	case "create":
 		fmt.Println("Usage:")
-		fmt.Println("\tyoke create name")
+		fmt.Println("\tyoke create [flags] name")
 		fmt.Println()
-		fmt.Println("***NOT YET IMPLEMENTED!***")
 		fmt.Println("Creates a new test.")
-		fmt.Println("The test will be placed in the directory [prefix]name, where [prefix] is the test name prefix specified in the yoke configuration file.")
+		fmt.Println("The test is placed in the directory name, in the current directory. The name must start with the test name prefix specified in the yoke configuration file.")
+		fmt.Println("The test profile is seeded from the default profile (or the template given with -template), and placeholders are created for its required files.")
+		fmt.Println("For flags, see 'yoke", args[0], "-h'")
+	case "diff":
+		fmt.Println("Usage:")
+		fmt.Println("\tyoke diff [flags] test1 [test2 [...]]")
+		fmt.Println()
+		fmt.Println("Shows the unified diffs for the match rules which failed in the last run of the specified tests.")
+		fmt.Println("The tests are not run again.")
+		fmt.Println("Context lines and truncation limits are set in the \"diff\" section of the yoke configuration file.")
+		fmt.Println()
+		fmt.Println("For flags, see 'yoke", args[0], "-h'")
+	case "init":
+		fmt.Println("Usage:")
+		fmt.Println("\tyoke init [flags]")
+		fmt.Println()
+		fmt.Println("Generates a yoke configuration file (" + defaultConfigFile + ") in the current directory.")
+		fmt.Println("Settings are taken from the flags, asked for one at a time (with -i), or inferred from the files shared by existing test directories (with -infer).")
+		fmt.Println()
 		fmt.Println("For flags, see 'yoke", args[0], "-h'")
 	case "list":
 		fmt.Println("Usage:")
-		fmt.Println("\tyoke list [flags]")
+		fmt.Println("\tyoke list [flags] [test1 [test2 [...]]]")
 		fmt.Println()
 		fmt.Println("Lists tests.")
+		fmt.Println("The same test selection flags as 'yoke run' (-run, -skip, -tags, -exclude-tags, -failed, -new, -shard and -shard-balance) are accepted.")
+		fmt.Println()
+		fmt.Println("For flags, see 'yoke", args[0], "-h'")
+	case "profile":
+		fmt.Println("Usage:")
+		fmt.Println("\tyoke profile [flags] test")
+		fmt.Println()
+		fmt.Println("Shows a test's profile as JSON.")
+		fmt.Println("Flags may be given before or after the test.")
+		fmt.Println("With -resolved, the profile is shown after the default profile has been applied, along with the source of each setting:")
+		fmt.Println("\ttest\tthe test's own profile")
+		fmt.Println("\tdefault\tthe default profile in the yoke configuration file")
+		fmt.Println("\tbuilt-in\tyoke's built-in defaults")
+		fmt.Println()
+		fmt.Println("For flags, see 'yoke", args[0], "-h'")
+	case "validate":
+		fmt.Println("Usage:")
+		fmt.Println("\tyoke validate [test1 [test2 [...]]]")
+		fmt.Println()
+		fmt.Println("Checks the yoke configuration file and the profiles of the specified tests (or all tests) without running anything.")
+		fmt.Println("Unknown fields and values of the wrong type are reported with their file, line and column.")
+		fmt.Println("Match and rmatch rules with fewer than two files, missing stdin files and regular expression files which don't compile are also reported.")
+		fmt.Println("The same checks can be run before running tests with 'yoke run -validate'.")
+	case "watch":
+		fmt.Println("Usage:")
+		fmt.Println("\tyoke watch [flags] [test1 [test2 [...]]]")
+		fmt.Println()
+		fmt.Println("Runs the specified tests (or all tests), then keeps checking for changes and reruns the tests affected by them.")
+		fmt.Println("A test is affected by changes to the files in its directory (other than the ones it writes itself) and to the paths listed in the \"watch\" setting of its profile.")
+		fmt.Println("Changes to the paths given with -paths (e.g., the program being tested) affect every test.")
+		fmt.Println("The same test selection flags as 'yoke run' are accepted.")
 		fmt.Println()
 		fmt.Println("For flags, see 'yoke", args[0], "-h'")
 	case "version":
@@ -111,25 +248,40 @@ func printUsage(args []string) {
 	}
 }
 
+// Give up on a missing or broken configuration file. The exit code policy
+// can't be trusted yet, so the default error exit code is used.
+func configFatal(v ...interface{}) {
+	log.Print(v...)
+	os.Exit(defaultErrorExitCode)
+}
+
 func loadConfig() {
 
 	// Load/parse default config file
 	configFile, err := os.Stat(defaultConfigFile)
 	if err != nil || configFile.IsDir() {
-		log.Fatal("No default configuration file found: ", err)
+		configFatal("No default configuration file found: ", err)
 	}
 
 	configBytes, err := ioutil.ReadFile(configFile.Name())
 	if err != nil {
-		log.Fatal("Unable to read config file: ", err)
+		configFatal("Unable to read config file: ", err)
 	}
 
 	err = json.Unmarshal(configBytes, &config)
 	if err != nil {
-		log.Fatal("Unable to unmarshal default config JSON: ", err)
+		configFatal("Unable to unmarshal default config JSON: ", err)
 	}
 
+	err = config.DefaultProfile.checkGroups()
+	if err != nil {
+		configFatal("Invalid pass conditions in the default profile: ", err)
+	}
+
+	configuredDefaultProfile = config.DefaultProfile
 	config.DefaultProfile.fixNullReferences()
+	config.Diff.fixNullReferences()
+	config.Exit.fixNullReferences()
 
 	if config.Maxthreads <= 0 {
 		config.Maxthreads = runtime.NumCPU()
@@ -149,12 +301,24 @@ func parseArgs(args []string) (command string, parsedArgs []string) {
 		switch parsedArgs[0] {
 		case "help":
 			fallthrough
+		case "accept":
+			fallthrough
 		case "run":
 			fallthrough
 		case "create":
 			fallthrough
+		case "diff":
+			fallthrough
+		case "init":
+			fallthrough
 		case "list":
 			fallthrough
+		case "profile":
+			fallthrough
+		case "validate":
+			fallthrough
+		case "watch":
+			fallthrough
 		case "version":
 			command = parsedArgs[0]
 			parsedArgs = parsedArgs[1:]
@@ -166,34 +330,153 @@ func parseArgs(args []string) (command string, parsedArgs []string) {
 	return
 }
 
-func runTests(args []string) {
+func runTests(args []string) (exitCode int) {
 	runFlags := flag.NewFlagSet("run", flag.ExitOnError)
 
 	verbose := runFlags.Bool("verbose", false, "show all output")
 	showInfo := runFlags.Bool("info", false, "show info output")
 	showWarnings := runFlags.Bool("warnings", false, "show warnings")
+	validate := runFlags.Bool("validate", false, "check the configuration and test profiles before running any tests")
+	var filter testFilter
+	filter.addFlags(runFlags)
+	format := runFlags.String("format", "text", "output format: text, tap (Test Anything Protocol) or json (a stream of events)")
+	jsonEvents := runFlags.Bool("json", false, "same as -format json")
+	var reports reportFlag
+	runFlags.Var(&reports, "report", "write a report of the run, given as kind=path (kinds: "+reportKinds()+"; may be repeated)")
 	runFlags.Parse(args)
 
+	if *validate && !validateConfig() {
+		return defaultErrorExitCode
+	}
+	loadConfig()
+
+	err := filter.compile()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return *config.Exit.Error
+	}
+	if *jsonEvents {
+		*format = "json"
+	}
+	if *format != "text" && *format != "tap" && *format != "json" {
+		fmt.Fprintln(os.Stderr, "Unknown output format: "+*format)
+		return *config.Exit.Error
+	}
+
 	if *verbose {
 		*showInfo = true
 		*showWarnings = true
 	}
-	tests := list.New()
+	if *validate && !validateTests(runFlags.Args()) {
+		return *config.Exit.Error
+	}
+
+	var reporters reporterList
+	switch *format {
+	case "text":
+		text := newTextReporter(*showWarnings, *showInfo)
+		if text.live {
+			echoCommands = false // They would break up the status line
+		}
+		reporters = append(reporters, text)
+	case "tap":
+		echoCommands = false
+		commandStdout = os.Stderr
+		verboseOutput = os.Stderr
+		reporters = append(reporters, &tapReporter{w: os.Stdout})
+	case "json":
+		echoCommands = false
+		commandStdout = os.Stderr
+		verboseOutput = os.Stderr
+		reporters = append(reporters, newJSONReporter(os.Stdout))
+	}
+	for _, v := range reports {
+		reporters = append(reporters, &fileReporter{spec: v})
+	}
+	reporterErr := false
+	for _, v := range config.Reporters {
+		r, err := newExternalReporter(v)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			reporterErr = true
+			continue
+		}
+		reporters = append(reporters, r)
+	}
+	listeners := reporters.eventListeners()
+	events := &eventBuffer{target: listeners}
+	if len(listeners) > 0 {
+		defaultEventListener = events
+	}
+
+	start := time.Now()
+	tests, loadErr := loadTests(runFlags.Args(), *verbose, &filter)
+	reporters.suiteStart(tests)
+	events.start()
+	runTestList(tests, reporters)
+	saveAllDiffs(tests)
+
+	elapsed := time.Since(start)
+	err = saveDurations(tests)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Unable to save test durations: "+err.Error())
+	}
+	err = saveLastRun(tests, start, elapsed)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Unable to save the state of the run: "+err.Error())
+	}
+
+	summary := newSummary(tests, elapsed)
+	if reporters.suiteEnd(tests, summary) != nil || reporterErr || loadErr != nil {
+		summary.errored = true
+	}
+	return summary.exitCode()
+}
+
+// Print the results of each test
+func printResults(tests *list.List, showWarnings, showInfo bool) {
+	for e := tests.Front(); e != nil; e = e.Next() {
+		var currTest *test
+		currTest = e.Value.(*test)
+		currTest.results.print(showWarnings, showInfo)
+		// fmt.Println(currTest.profile.String())
+	}
+}
 
-	// Build list of tests and load profiles
-	if len(runFlags.Args()) > 0 { // Get specified tests
-		if *verbose {
-			fmt.Printf("Attempting to run tests: %v\n", runFlags.Args())
+// Save the diffs of each test for 'yoke diff'
+func saveAllDiffs(tests *list.List) {
+	for e := tests.Front(); e != nil; e = e.Next() {
+		currTest := e.Value.(*test)
+		err := currTest.saveDiffs()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Unable to save diffs for "+currTest.testName+": "+err.Error())
 		}
-		for _, filename := range runFlags.Args() {
-			fi, err := os.Stat(filename)
-			if err != nil {
+	}
+}
+
+// Where -verbose diagnostics go. Machine-readable formats send them to
+// stderr, like the output of commands.
+var verboseOutput io.Writer = os.Stdout
+
+// Find the names of the tests to use. If no names are given, every test
+// directory starting with the configured prefix is used.
+// An error is returned if any of the named tests couldn't be opened.
+func findTests(names []string, verbose bool) (found []string, err error) {
+	found = make([]string, 0)
+
+	if len(names) > 0 { // Get specified tests
+		if verbose {
+			fmt.Fprintf(verboseOutput, "Attempting to run tests: %v\n", names)
+		}
+		for _, filename := range names {
+			fi, statErr := os.Stat(filename)
+			if statErr != nil {
 				fmt.Fprintf(os.Stderr, "Unable to open: %s\n", filename)
+				err = errors.New("Unable to open: " + filename)
 				continue
 			}
 			if fi.IsDir() {
-				t := newTest(fi.Name())
-				tests.PushBack(t)
+				found = append(found, fi.Name())
 			}
 		}
 
@@ -201,15 +484,44 @@ func runTests(args []string) {
 		testFiles, _ := ioutil.ReadDir("./")
 		for _, f := range testFiles {
 			if f.IsDir() && strings.HasPrefix(f.Name(), config.Prefix) {
-				if *verbose {
-					fmt.Println("Test found: " + f.Name())
+				if verbose {
+					fmt.Fprintln(verboseOutput, "Test found: "+f.Name())
 				}
-				t := newTest(f.Name())
-				tests.PushBack(t)
+				found = append(found, f.Name())
 			}
 		}
 	}
+	return
+}
 
+// Build list of tests and load profiles, keeping only the tests selected by
+// the filter (if any)
+func loadTests(names []string, verbose bool, filter *testFilter) (tests *list.List, err error) {
+	tests = list.New()
+	found, err := findTests(names, verbose)
+	selected := make(map[string]*test)
+	selectedNames := make([]string, 0)
+	for _, name := range found {
+		if !filter.matchName(name) {
+			continue
+		}
+		t := newTest(name)
+		if !filter.matchProfile(t.rootProfile) {
+			continue
+		}
+		selected[name] = t
+		selectedNames = append(selectedNames, name)
+	}
+	for _, name := range filter.selectShard(selectedNames) {
+		tests.PushBack(selected[name])
+	}
+	return
+}
+
+// Run a list of tests. Concurrent tests are run first, followed by the
+// noconcurrent ones, one at a time. If r isn't nil, it's told about each
+// test as it runs (possibly from several goroutines at once).
+func runTestList(tests *list.List, r reporter) {
 	numConcurrent := 0
 
 	// Handle tests
@@ -222,6 +534,7 @@ func runTests(args []string) {
 		if currTest.profile.Noconcurrent != nil && !*currTest.profile.Noconcurrent {
 			c <- true // If there are > maxthreads running, wait for one to finish
 			wg.Add(1)
+			currTest.reporter = r
 			go currTest.runInThread(c, &wg)
 
 			numConcurrent++
@@ -234,38 +547,37 @@ func runTests(args []string) {
 		var currTest *test
 		currTest = e.Value.(*test)
 		if currTest.profile.Noconcurrent != nil && *currTest.profile.Noconcurrent {
-			currTest.run()
+			currTest.reporter = r
+			currTest.execute()
 		}
 	}
-
-	for e := tests.Front(); e != nil; e = e.Next() {
-		var currTest *test
-		currTest = e.Value.(*test)
-		currTest.results.print(*showWarnings, *showInfo)
-		// fmt.Println(currTest.profile.String())
-	}
-
 }
 
-func listTests(args []string) {
+func listTests(args []string) error {
 	listFlags := flag.NewFlagSet("list", flag.ExitOnError)
 
 	showProfiles := listFlags.Bool("profiles", false, "show test profiles details")
+	var filter testFilter
+	filter.addFlags(listFlags)
 	listFlags.Parse(args)
 
-	testFiles, _ := ioutil.ReadDir("./")
-	for _, f := range testFiles {
-		if f.IsDir() && strings.HasPrefix(f.Name(), config.Prefix) {
-			if *showProfiles {
-				// print test profile
-				fmt.Println("\n==============================")
-				fmt.Println("Test: " + f.Name())
-				t := newTest(f.Name())
-				fmt.Println(t.profile.String())
-				fmt.Println("==============================")
-			} else {
-				fmt.Println(f.Name())
-			}
+	err := filter.compile()
+	if err != nil {
+		return err
+	}
+
+	tests, err := loadTests(listFlags.Args(), false, &filter)
+	for e := tests.Front(); e != nil; e = e.Next() {
+		t := e.Value.(*test)
+		if *showProfiles {
+			// print test profile
+			fmt.Println("\n==============================")
+			fmt.Println("Test: " + t.testName)
+			fmt.Println(t.profile.String())
+			fmt.Println("==============================")
+		} else {
+			fmt.Println(t.testName)
 		}
 	}
+	return err
 }